@@ -0,0 +1,160 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages reading calendars from more than one Google account.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Accounts are given in the config file as named tables:
+//   [account.personal]
+//   calendars = ["primary"]
+//   clientSecret = "personal_secret.json"
+//
+// Each account is authorized separately, with its own saved token.  Its calendars are added to
+// the calendars list as "personal/primary", which is also how rules refer to them.  The calendars
+// list itself belongs to the default account, which uses -clientsecret.
+
+// Account is a Google account other than the default one.
+type Account struct {
+	Name         string
+	ClientSecret string
+	Calendars    []string
+}
+
+// Struct used for decoding an account from TOML.
+type tomlAccount struct {
+	ClientSecret string
+	Calendars    []string
+}
+
+// makeAccounts converts the accounts read from the config file, sorted by name.
+func makeAccounts(layouts map[string]tomlAccount) ([]*Account, error) {
+	var accounts []*Account
+	for name, layout := range layouts {
+		if name == "" || strings.Contains(name, "/") {
+			return nil, fmt.Errorf("bad account name %q", name)
+		}
+		account := &Account{Name: name, ClientSecret: layout.ClientSecret, Calendars: layout.Calendars}
+		accounts = append(accounts, account)
+	}
+	sort.Slice(accounts, func(i, j int) bool {
+		return accounts[i].Name < accounts[j].Name
+	})
+	return accounts, nil
+}
+
+// accountCalendarID returns the calendar ID used for a calendar in the given account.  Calendars
+// in the default account keep their own ID.
+func accountCalendarID(account string, calendarID string) string {
+	if account == "" {
+		return calendarID
+	}
+	return account + "/" + calendarID
+}
+
+// googleAccounts holds the Calendar service for each Google account with calendars, by account
+// name.  The default account's name is "".
+type googleAccounts map[string]*calendar.Service
+
+// connectAccounts connects to every Google account that has calendars.
+func connectAccounts(userPrefs *UserPrefs) googleAccounts {
+	accounts := make(googleAccounts)
+	if len(defaultCalendars(userPrefs)) > 0 {
+		srv, err := Connect("", *clientSecretFlag, false)
+		if err != nil {
+			log.Fatalf("Unable to retrieve Calendar client: %v", err)
+		}
+		accounts[""] = srv
+	}
+	for _, account := range userPrefs.Accounts {
+		if len(account.Calendars) == 0 {
+			continue
+		}
+		// Accounts without their own client secret use the default account's.
+		clientSecret := account.ClientSecret
+		if clientSecret == "" {
+			clientSecret = *clientSecretFlag
+		}
+		srv, err := Connect(account.Name, clientSecret, false)
+		if err != nil {
+			log.Fatalf("Unable to retrieve Calendar client for account %v: %v", account.Name, err)
+		}
+		accounts[account.Name] = srv
+	}
+	return accounts
+}
+
+// namedCalendars returns the calendars that belong to the named accounts, as account calendar IDs.
+func namedCalendars(userPrefs *UserPrefs) []string {
+	var calendars []string
+	for _, account := range userPrefs.Accounts {
+		for _, calendarID := range account.Calendars {
+			calendars = append(calendars, accountCalendarID(account.Name, calendarID))
+		}
+	}
+	return calendars
+}
+
+// defaultCalendars returns the calendars that belong to the default account.
+func defaultCalendars(userPrefs *UserPrefs) []string {
+	named := make(map[string]bool)
+	for _, calendarID := range namedCalendars(userPrefs) {
+		named[calendarID] = true
+	}
+	var calendars []string
+	for _, calendarID := range userPrefs.Calendars {
+		if !named[calendarID] {
+			calendars = append(calendars, calendarID)
+		}
+	}
+	return calendars
+}
+
+// listers returns a lister for every account, each made by makeLister from its service.
+func (accounts googleAccounts) listers(makeLister func(*calendar.Service) CalendarLister) *accountLister {
+	lister := &accountLister{listers: make(map[string]CalendarLister)}
+	for name, srv := range accounts {
+		lister.listers[name] = makeLister(srv)
+	}
+	return lister
+}
+
+// accountLister sends each List call to the lister for the calendar's account.
+type accountLister struct {
+	listers map[string]CalendarLister
+}
+
+func (lister *accountLister) List(calendarID string, start time.Time, end time.Time) ([]*calendar.Event, error) {
+	account, id := "", calendarID
+	if slash := strings.Index(calendarID, "/"); slash > 0 {
+		if _, ok := lister.listers[calendarID[:slash]]; ok {
+			account, id = calendarID[:slash], calendarID[slash+1:]
+		}
+	}
+	inner, ok := lister.listers[account]
+	if !ok {
+		return nil, fmt.Errorf("no account for calendar %v", calendarID)
+	}
+	return inner.List(id, start, end)
+}
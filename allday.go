@@ -0,0 +1,121 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages showing all-day events.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"time"
+
+	blink1 "github.com/kazrakcom/go-blink1"
+	"google.golang.org/api/calendar/v3"
+)
+
+// All-day events are only shown if they match an entry in the config file:
+//   [[allDay]]
+//   title = "(?i)on call"
+//   color = "#002020"
+//   pulse = true
+//   led = 2
+//
+// The first entry whose title pattern matches is used.  The color is shown on one LED (1 by
+// default) for the whole day, pulsing slowly if pulse is set, whenever there is nothing else
+// to show.
+
+// How long each half of the slow pulse for all-day events takes.
+const allDayPulse = 2 * time.Second
+
+// AllDayStyle is how all-day events with matching titles are shown.
+type AllDayStyle struct {
+	Title *regexp.Regexp
+	State CalendarState
+}
+
+// Struct used for decoding an all-day style from TOML.
+type tomlAllDay struct {
+	Title string
+	Color string
+	Pulse bool
+	LED   int64
+}
+
+func makeAllDayStyle(layout tomlAllDay) (*AllDayStyle, error) {
+	title, err := regexp.Compile(layout.Title)
+	if err != nil {
+		return nil, fmt.Errorf("bad title pattern %v: %v", layout.Title, err)
+	}
+	color, err := parseColor(layout.Color)
+	if err != nil {
+		return nil, err
+	}
+	state := CalendarState{Name: "All Day " + layout.Title}
+	flash := time.Duration(0)
+	if layout.Pulse {
+		flash = allDayPulse
+	}
+	switch layout.LED {
+	case 0, 1:
+		state.primary, state.secondary = color, blink1.OffState
+		state.primaryFlash = flash
+	case 2:
+		state.primary, state.secondary = blink1.OffState, color
+		state.secondaryFlash = flash
+	default:
+		return nil, fmt.Errorf("bad LED %v for %v, should be 1 or 2", layout.LED, layout.Title)
+	}
+	return &AllDayStyle{Title: title, State: state}, nil
+}
+
+// makeAllDayStyles converts the all-day styles read from the config file, in order.
+func makeAllDayStyles(layouts []tomlAllDay) ([]*AllDayStyle, error) {
+	var styles []*AllDayStyle
+	for _, layout := range layouts {
+		style, err := makeAllDayStyle(layout)
+		if err != nil {
+			return nil, err
+		}
+		styles = append(styles, style)
+	}
+	return styles, nil
+}
+
+func isAllDayEvent(event *calendar.Event) bool {
+	return event.Start.DateTime == ""
+}
+
+// allDayStyleFor returns the style for an all-day event, or nil if it isn't shown.
+func allDayStyleFor(event *calendar.Event, userPrefs *UserPrefs) *AllDayStyle {
+	for _, style := range userPrefs.AllDay {
+		if style.Title.MatchString(event.Summary) {
+			return style
+		}
+	}
+	return nil
+}
+
+// allDayState returns the state for the first all-day event in progress, and false if none are.
+func allDayState(events []*calendar.Event, now time.Time, userPrefs *UserPrefs) (CalendarState, bool) {
+	for _, event := range events {
+		if eventStartTime(event).After(now) || !eventEndTime(event).After(now) {
+			continue
+		}
+		if style := allDayStyleFor(event, userPrefs); style != nil {
+			return style.State, true
+		}
+	}
+	return Black, false
+}
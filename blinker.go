@@ -53,6 +53,9 @@ var (
 	BlueFlash    = CalendarState{Name: "Red-Blue Flash", primary: blink1.State{Blue: 255}, secondary: blink1.State{Red: 255}, primaryFlash: time.Duration(500) * time.Millisecond, alternate: true}
 	Blue         = CalendarState{Name: "Blue", primary: blink1.State{Blue: 255}, secondary: blink1.State{Blue: 255}}
 	MagentaFlash = CalendarState{Name: "MagentaFlash", primary: blink1.State{Red: 255, Blue: 255}, secondary: blink1.OffState, primaryFlash: time.Duration(125) * time.Millisecond, alternate: true}
+	DimMagenta   = CalendarState{Name: "Dim Magenta", primary: blink1.State{Red: 64, Blue: 64}, secondary: blink1.State{Red: 64, Blue: 64}}
+	DimPurple    = CalendarState{Name: "Dim Purple", primary: blink1.State{Red: 32, Blue: 64}, secondary: blink1.State{Red: 32, Blue: 64}}
+	DimOrange    = CalendarState{Name: "Dim Orange", primary: blink1.State{Red: 48, Green: 24}, secondary: blink1.State{Red: 48, Green: 24}}
 )
 
 // Combines the two states into one state that shows both events
@@ -77,6 +80,17 @@ func SwapState(in CalendarState) CalendarState {
 	return swapped
 }
 
+// Marks a state as being based on out of date events, by showing it on LED 1 only and dim magenta on LED 2
+func MarkStale(in CalendarState) CalendarState {
+	stale := CalendarState{Name: in.Name + " (stale)",
+		primary:        in.primary,
+		secondary:      DimMagenta.primary,
+		primaryFlash:   in.primaryFlash,
+		secondaryFlash: 0,
+		alternate:      false}
+	return stale
+}
+
 // blinkerState encapsulates the current device state of the blink(1).
 type BlinkerState struct {
 	device      *blink1.Device
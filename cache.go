@@ -0,0 +1,167 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages the cache of fetched events that the display is computed from.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// The name of the cache file in the state directory.
+const cacheFileName = "events.json"
+
+// EventCache holds the filtered events from the last successful fetch.  The display is
+// recomputed from it every second, so the light changes on time however long it has been
+// since the last fetch.  The cache is also saved to disk, so that after a restart calblink
+// can show the right thing straight away.
+type EventCache struct {
+	Events []*calendar.Event
+	// The calendar ID or source name each event came from, by event ID.
+	Calendars map[string]string
+	// The name of the profile each event is shown with, by event ID.
+	Profiles map[string]string
+	// How long it takes to get to each event, by event ID.
+	Travel      map[string]time.Duration
+	OutOfOffice []OutOfOffice
+	AllDay      []*calendar.Event
+	// When the user is back from a long out of office period, if they are on one.
+	AwayUntil time.Time
+	Fetched   time.Time
+
+	path   string
+	maxAge time.Duration
+}
+
+// NewEventCache creates a cache saved in the given state directory, loading the events saved
+// there if they are newer than maxAge.  If stateDir is empty, the cache is only kept in memory.
+func NewEventCache(stateDir string, maxAge time.Duration) *EventCache {
+	cache := &EventCache{maxAge: maxAge}
+	if stateDir == "" {
+		return cache
+	}
+	cache.path = filepath.Join(stateDir, cacheFileName)
+	data, err := os.ReadFile(cache.path)
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			errorLog("Unable to read event cache %v: %v\n", cache.path, err)
+		}
+		return cache
+	}
+	saved := EventCache{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		errorLog("Unable to parse event cache %v: %v\n", cache.path, err)
+		return cache
+	}
+	if time.Since(saved.Fetched) > maxAge {
+		debugLog("Ignoring event cache from %v because it is too old\n", saved.Fetched)
+		return cache
+	}
+	debugLog("Loaded %d cached events from %v\n", len(saved.Events), saved.Fetched)
+	cache.Events = saved.Events
+	cache.Calendars = saved.Calendars
+	cache.Profiles = saved.Profiles
+	cache.Travel = saved.Travel
+	cache.OutOfOffice = saved.OutOfOffice
+	cache.AllDay = saved.AllDay
+	cache.AwayUntil = saved.AwayUntil
+	cache.Fetched = saved.Fetched
+	return cache
+}
+
+// Update replaces the cached events with the result of a fetch made at the given time, and
+// saves them to disk.
+func (cache *EventCache) Update(events *fetchedEvents, fetched time.Time) {
+	cache.Events = events.items
+	cache.Calendars = events.calendars
+	cache.Profiles = events.profiles
+	cache.Travel = events.travel
+	cache.OutOfOffice = events.outOfOffice
+	cache.AllDay = events.allDay
+	cache.AwayUntil = events.awayUntil
+	cache.Fetched = fetched
+	if cache.path == "" {
+		return
+	}
+	if err := cache.save(); err != nil {
+		errorLog("Unable to save event cache %v: %v\n", cache.path, err)
+	}
+}
+
+func (cache *EventCache) save() error {
+	data, err := json.Marshal(cache)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(cache.path), 0700); err != nil {
+		return err
+	}
+	// Write to a temporary file first, so a crash mid-write can't leave a corrupt cache.
+	temp := cache.path + ".tmp"
+	if err := os.WriteFile(temp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(temp, cache.path)
+}
+
+// Expired returns true if there are no cached events or they are older than the freshness limit.
+func (cache *EventCache) Expired(now time.Time) bool {
+	return cache.Fetched.IsZero() || now.Sub(cache.Fetched) > cache.maxAge
+}
+
+// Age returns how long ago the cached events were fetched.
+func (cache *EventCache) Age(now time.Time) time.Duration {
+	return now.Sub(cache.Fetched)
+}
+
+// State returns the display state for the cached events at the given time.  Expired events
+// aren't trusted, so nothing is shown for them.  Meetings take priority over focus time, focus
+// time over being out of office, and that over all-day events, so those colors are only shown
+// when no meeting is.
+func (cache *EventCache) State(now time.Time, userPrefs *UserPrefs) CalendarState {
+	if cache.Expired(now) {
+		return Black
+	}
+	meetings, focus := splitFocusEvents(cache.Events, userPrefs)
+	state := blinkStateForEvent(nextEvent(meetings, now, userPrefs), cache, now, userPrefs)
+	if state == Black && inFocusTime(focus, now) {
+		return userPrefs.FocusTimeState
+	}
+	if state == Black && userPrefs.OutOfOffice == OutOfOfficeAway && awayAt(cache.OutOfOffice, now) {
+		return userPrefs.OutOfOfficeState
+	}
+	if state == Black {
+		if allDay, ok := allDayState(cache.AllDay, now, userPrefs); ok {
+			return allDay
+		}
+	}
+	return state
+}
+
+// IdleUntil returns when to wake up if the user is on a long out of office period, or the zero
+// time if they aren't.
+func (cache *EventCache) IdleUntil(now time.Time) time.Time {
+	if cache.Expired(now) || !cache.AwayUntil.After(now) {
+		return time.Time{}
+	}
+	return cache.AwayUntil
+}
@@ -19,9 +19,11 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/kardianos/service"
+	"google.golang.org/api/calendar/v3"
 )
 
 // flags
@@ -96,6 +98,8 @@ func printDot(s string) {
 
 func usage() {
 	fmt.Fprintf(os.Stderr, "Usage:\n")
+	fmt.Fprintf(os.Stderr, "  calblink [flags]\n")
+	fmt.Fprintf(os.Stderr, "  calblink [flags] explain <event title>\n")
 	flag.PrintDefaults()
 }
 
@@ -119,7 +123,8 @@ func main() {
 	flag.Visit(func(myFlag *flag.Flag) {
 		switch myFlag.Name {
 		case "calendar":
-			userPrefs.Calendars = []string{myFlag.Value.String()}
+			// Only the default account's calendars are replaced; named accounts keep theirs.
+			userPrefs.Calendars = append([]string{myFlag.Value.String()}, namedCalendars(userPrefs)...)
 		case "poll_interval":
 			userPrefs.PollInterval = myFlag.Value.(flag.Getter).Get().(int)
 		case "response_state":
@@ -147,6 +152,11 @@ func main() {
 		exit:      make(chan struct{}),
 	}
 
+	if flag.Arg(0) == "explain" {
+		runExplain(userPrefs, strings.Join(flag.Args()[1:], " "))
+		return
+	}
+
 	if isService {
 		prg.StartService(serviceCmd)
 	} else {
@@ -155,12 +165,40 @@ func main() {
 
 }
 
+// makeLister connects to each Google account with calendars and returns the lister for the
+// configured calendars, along with the accounts.
+func makeLister(userPrefs *UserPrefs) (*accountLister, googleAccounts) {
+	accounts := connectAccounts(userPrefs)
+	lister := accounts.listers(func(srv *calendar.Service) CalendarLister {
+		if userPrefs.IncrementalSync {
+			return NewSyncEngine(srv, userPrefs.MaxEventsPerCalendar)
+		}
+		return googleLister{srv: srv, maxEvents: userPrefs.MaxEventsPerCalendar}
+	})
+	return lister, accounts
+}
+
 func runLoop(p *program) {
 	userPrefs := p.userPrefs
-	srv, err := Connect()
-	if err != nil {
-		log.Fatalf("Unable to retrieve Calendar client: %v", err)
+	var watcher *CalendarWatcher
+	var notifications chan struct{}
+	lister, accounts := makeLister(userPrefs)
+	srv := accounts[""]
+	if srv != nil && userPrefs.PushAddress != "" {
+		// Only the default account's calendars are watched; other accounts are polled.
+		watcher = NewCalendarWatcher(srv, defaultCalendars(userPrefs), userPrefs.PushAddress)
+		watcher.Start(userPrefs.PushListenAddress, userPrefs.PushCertFile, userPrefs.PushKeyFile)
+		lister.listers[""] = NewWatchedLister(lister.listers[""], watcher, time.Duration(userPrefs.PushFallbackInterval)*time.Second)
+		notifications = watcher.Notifications
+	}
+	sources := makeEventSources(userPrefs, srv)
+	var manualChanges chan struct{}
+	for _, source := range sources {
+		if manual, ok := source.(*ManualSource); ok {
+			manualChanges = manual.Notifications
+		}
 	}
+	invitations := NewInvitationTracker(accounts, userPrefs)
 
 	blinkerState := NewBlinkerState(userPrefs.DeviceFailureRetries)
 
@@ -174,73 +212,126 @@ func runLoop(p *program) {
 	}
 
 	ticker := time.NewTicker(time.Second)
-	nextEvent := time.Now()
+	// sleepUntil is when to wake up after turning off for a skip day or time restriction.
+	sleepUntil := time.Now()
+	nextFetch := time.Now()
 	failures := 0
+	// Cached events can't be trusted past the end of the window they were fetched for.
+	cacheMaxAge := time.Duration(userPrefs.CacheMaxAge) * time.Second
+	if lookahead := time.Duration(userPrefs.Lookahead) * time.Minute; cacheMaxAge > lookahead {
+		debugLog("Limiting cache age to the lookahead window of %v\n", lookahead)
+		cacheMaxAge = lookahead
+	}
+	cache := NewEventCache(userPrefs.StateDirectory, cacheMaxAge)
+	staleAge := time.Duration(userPrefs.StaleCacheAge) * time.Second
+	scheduler := NewPollScheduler(userPrefs)
+	lastState := Black
 
 	for {
 		select {
 		case <-p.exit:
 			blinkerState.turnOff()
+			if watcher != nil {
+				watcher.Stop()
+			}
 			fmt.Printf("Calblink exiting at %v\n", time.Now())
 			ticker.Stop()
 			return
+		case <-notifications:
+			debugLog("Calendar changed, fetching now\n")
+			nextFetch = time.Now()
+		case <-manualChanges:
+			debugLog("Manual events changed, fetching now\n")
+			nextFetch = time.Now()
 		case now := <-ticker.C:
-			if nextEvent.After(now) {
+			if sleepUntil.After(now) {
 				continue
 			}
 			weekday := now.Weekday()
 			if userPrefs.SkipDays[weekday] {
 				tomorrow := tomorrow()
 				Black.Execute(blinkerState)
+				lastState = Black
 				debugLog("Sleeping until tomorrow (%v) because it's a skip day\n", tomorrow)
 				printDot("~")
-				nextEvent = tomorrow
+				sleepUntil = tomorrow
 				continue
 			}
 			if userPrefs.StartTime != nil {
 				start := setHourMinuteFromTime(*userPrefs.StartTime)
-				debugLog("Start time: %v\n", start)
+				verboseLog("Start time: %v\n", start)
 				if diff := time.Since(start); diff < 0 {
 					Black.Execute(blinkerState)
+					lastState = Black
 					debugLog("Sleeping %v because start time after now\n", -diff)
 					printDot(">")
-					nextEvent = start
+					sleepUntil = start
 					continue
 				}
 			}
 			if userPrefs.EndTime != nil {
 				end := setHourMinuteFromTime(*userPrefs.EndTime)
-				debugLog("End time: %v\n", end)
+				verboseLog("End time: %v\n", end)
 				if diff := time.Since(end); diff > 0 {
 					Black.Execute(blinkerState)
+					lastState = Black
 					tomorrow := tomorrow()
 					untilTomorrow := tomorrow.Sub(now)
 					debugLog("Sleeping %v until tomorrow because end time %v before now\n", untilTomorrow, diff)
 					printDot("<")
-					nextEvent = tomorrow
+					sleepUntil = tomorrow
 					continue
 				}
 			}
-			next, err := fetchEvents(now, srv, userPrefs)
-			if err != nil {
-				// Leave the same color, set a flag. If we get more than a critical number of these,
-				// set the color to blinking magenta to tell the user we are in a failed state.
-				failures++
-				if failures > failureRetries {
-					MagentaFlash.Execute(blinkerState)
+			fetched := false
+			if !nextFetch.After(now) {
+				events, err := fetchEvents(now, lister, sources, userPrefs)
+				if err != nil {
+					// Keep showing the cached events, and count the failure. If we get more than a critical
+					// number of these and have no usable events, set the color to blinking magenta to tell the
+					// user we are in a failed state.
+					failures++
+					errorLog("Error receiving events from server:\n%v\n", err)
+					printDot(",")
+				} else {
+					failures = 0
+					cache.Update(events, now)
+					printDot(".")
+				}
+				nextFetch = scheduler.Next(now, err, cache, userPrefs)
+				fetched = true
+			}
+			if invitations.Poll(now, userPrefs) {
+				fetched = true
+			}
+			if idleUntil := cache.IdleUntil(now); !idleUntil.IsZero() {
+				// Out of office for a long time, so turn off and only check occasionally in case the
+				// plans change.
+				Black.Execute(blinkerState)
+				lastState = Black
+				wake := now.Add(outOfOfficeIdleRecheck)
+				if idleUntil.Before(wake) {
+					wake = idleUntil
 				}
-				errorLog("Error receiving events from server:\n%v\n", err)
-				printDot(",")
-				nextEvent = now.Add(time.Duration(userPrefs.PollInterval) * time.Second)
+				debugLog("Out of office until %v, sleeping until %v\n", idleUntil, wake)
+				printDot("z")
+				sleepUntil = wake
+				nextFetch = wake
 				continue
-			} else {
-				failures = 0
 			}
-			blinkState := blinkStateForEvent(next, userPrefs.PriorityFlashSide)
-
-			blinkState.Execute(blinkerState)
-			printDot(".")
-			nextEvent = now.Add(time.Duration(userPrefs.PollInterval) * time.Second)
+			blinkState := invitations.State(cache.State(now, userPrefs), userPrefs)
+			if cache.Expired(now) {
+				if failures > failureRetries {
+					blinkState = MagentaFlash
+				}
+			} else if failures > 0 && cache.Age(now) > staleAge {
+				blinkState = MarkStale(blinkState)
+			}
+			// Resend the state after every fetch even if it hasn't changed, so the device is retried if it failed.
+			if blinkState != lastState || fetched {
+				blinkState.Execute(blinkerState)
+				lastState = blinkState
+			}
 		}
 	}
 }
@@ -0,0 +1,145 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages reading events from CalDAV servers.
+
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// The calendar-query REPORT body.  Only events overlapping the time range are returned,
+// and the server returns whole calendar objects, so recurring events are expanded locally.
+const calDAVQuery = `<?xml version="1.0" encoding="utf-8" ?>
+<C:calendar-query xmlns:D="DAV:" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <C:calendar-data/>
+  </D:prop>
+  <C:filter>
+    <C:comp-filter name="VCALENDAR">
+      <C:comp-filter name="VEVENT">
+        <C:time-range start="%s" end="%s"/>
+      </C:comp-filter>
+    </C:comp-filter>
+  </C:filter>
+</C:calendar-query>`
+
+// calDAVMultistatus is the subset of a WebDAV multistatus response that calblink needs.
+type calDAVMultistatus struct {
+	Responses []struct {
+		Href      string `xml:"href"`
+		Propstats []struct {
+			Status       string `xml:"status"`
+			CalendarData string `xml:"prop>calendar-data"`
+		} `xml:"propstat"`
+	} `xml:"response"`
+}
+
+// CalDAVSource reads events from a single calendar collection on a CalDAV server.
+type CalDAVSource struct {
+	url        string
+	username   string
+	password   string
+	selfEmails map[string]bool
+	client     *http.Client
+}
+
+// NewCalDAVSource creates a source for the calendar collection at the given URL, using basic
+// auth if a username is given.  App passwords work the same way as regular passwords.
+func NewCalDAVSource(calendarURL string, username string, password string, selfEmails []string) *CalDAVSource {
+	source := &CalDAVSource{
+		url:        calendarURL,
+		username:   username,
+		password:   password,
+		selfEmails: make(map[string]bool),
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, email := range selfEmails {
+		source.selfEmails[strings.ToLower(email)] = true
+	}
+	return source
+}
+
+func (source *CalDAVSource) Name() string {
+	if u, err := url.Parse(source.url); err == nil {
+		return u.Redacted()
+	}
+	return source.url
+}
+
+func (source *CalDAVSource) Events(start time.Time, end time.Time) ([]*calendar.Event, error) {
+	const timeFormat = "20060102T150405Z"
+	body := fmt.Sprintf(calDAVQuery, start.UTC().Format(timeFormat), end.UTC().Format(timeFormat))
+	req, err := http.NewRequest("REPORT", source.url, strings.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("unable to create CalDAV request for %v: %v", source.Name(), err)
+	}
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+	req.Header.Set("Depth", "1")
+	if source.username != "" {
+		req.SetBasicAuth(source.username, source.password)
+	}
+	resp, err := source.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query CalDAV calendar %v: %v", source.Name(), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusMultiStatus && resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to query CalDAV calendar %v: %v", source.Name(), resp.Status)
+	}
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read CalDAV response from %v: %v", source.Name(), err)
+	}
+	multistatus := calDAVMultistatus{}
+	if err := xml.Unmarshal(data, &multistatus); err != nil {
+		return nil, fmt.Errorf("unable to parse CalDAV response from %v: %v", source.Name(), err)
+	}
+
+	var events []*calendar.Event
+	for _, response := range multistatus.Responses {
+		for _, propstat := range response.Propstats {
+			if propstat.CalendarData == "" {
+				continue
+			}
+			if !strings.Contains(propstat.Status, " 200 ") {
+				debugLog("Skipping CalDAV object %v with status %v\n", response.Href, propstat.Status)
+				continue
+			}
+			cal, err := parseICS([]byte(propstat.CalendarData))
+			if err != nil {
+				debugLog("Skipping unparseable CalDAV object %v: %v\n", response.Href, err)
+				continue
+			}
+			objectEvents, err := expandICSEvents(cal, start, end, source.selfEmails)
+			if err != nil {
+				debugLog("Skipping CalDAV object %v: %v\n", response.Href, err)
+				continue
+			}
+			events = append(events, objectEvents...)
+		}
+	}
+	sortEventsByStart(events)
+	debugLog("Read %d events from %v\n", len(events), source.Name())
+	return events, nil
+}
@@ -19,10 +19,10 @@ package main
 import (
 	"log"
 	"sort"
-	"strings"
 	"time"
 
 	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
 )
 
 // Event handling methods
@@ -37,100 +37,135 @@ func eventHasAcceptableResponse(item *calendar.Event, responseState ResponseStat
 	return true
 }
 
-func eventExcludedByPrefs(item string, userPrefs *UserPrefs) bool {
-	if userPrefs.Excludes[item] {
+// eventIncluded returns true if the rules and response state allow the event to be shown.
+func eventIncluded(item *calendar.Event, calendarID string, userPrefs *UserPrefs) bool {
+	if isAllDayEvent(item) && allDayStyleFor(item, userPrefs) == nil {
+		return false
+	}
+	if isFocusEvent(item) && userPrefs.FocusTime == FocusTimeIgnore {
+		debugLog("Skipping focus time event '%v'\n", item.Summary)
+		return false
+	}
+	rule, include := applyRules(item, calendarID, userPrefs)
+	if rule != "" {
+		if include {
+			debugLog("Including event '%v' due to %v\n", item.Summary, rule)
+		} else {
+			debugLog("Skipping event '%v' due to %v\n", item.Summary, rule)
+			return false
+		}
+	}
+	return eventHasAcceptableResponse(item, settingsFor(calendarID, userPrefs).ResponseState)
+}
+
+// locationsMatch returns true if one of the working locations is one the user wants events on
+// the calendar shown at, or if the user hasn't limited the locations.
+func locationsMatch(locations []WorkSite, calendarID string, userPrefs *UserPrefs) bool {
+	workingLocations := settingsFor(calendarID, userPrefs).WorkingLocations
+	if len(workingLocations) == 0 {
 		return true
 	}
-	for _, prefix := range userPrefs.ExcludePrefixes {
-		if strings.HasPrefix(item, prefix) {
-			debugLog("Skipping event '%v' due to prefix match '%v'\n", item, prefix)
+	locationSet := make(map[WorkSite]bool)
+	for _, location := range locations {
+		locationSet[location] = true
+	}
+	for _, prefLocation := range workingLocations {
+		if locationSet[prefLocation] {
+			debugLog("Found matching location: %v\n", prefLocation)
 			return true
 		}
 	}
 	return false
 }
 
-func nextEvent(items []*calendar.Event, locations []WorkSite, userPrefs *UserPrefs) []*calendar.Event {
+// filterEvents returns the events that the user preferences allow to be shown.
+func filterEvents(fetched *fetchedEvents, userPrefs *UserPrefs) []*calendar.Event {
 	var events []*calendar.Event
 
-	if len(userPrefs.WorkingLocations) > 0 {
-		match := false
-		locationSet := make(map[WorkSite]bool)
-		for _, location := range locations {
-			locationSet[location] = true
+	for _, i := range fetched.items {
+		calendarID := fetched.calendars[i.Id]
+		if outOfOfficeAt(fetched.outOfOffice, calendarID, eventStartTime(i)) {
+			debugLog("Skipping event '%v' because it starts while out of office\n", i.Summary)
+			continue
 		}
-
-		for _, prefLocation := range userPrefs.WorkingLocations {
-			if locationSet[prefLocation] {
-				debugLog("Found matching location: %v\n", prefLocation)
-				match = true
-				break
+		locations := eventLocations(i, calendarID, fetched.locations)
+		if !locationsMatch(locations, calendarID, userPrefs) {
+			debugLog("Skipping event '%v' due to no matching locations in %v\n", i.Summary, locations)
+			continue
+		}
+		if profile := profileForLocations(locations, userPrefs); profile != nil {
+			if profile.Off {
+				debugLog("Skipping event '%v' because profile %v is off\n", i.Summary, profile.Name)
+				continue
 			}
+			fetched.profiles[i.Id] = profile.Name
 		}
-
-		if !match {
-			debugLog("Skipping all events due to no matching locations in %v\n", locations)
-			return events
+		if !eventIncluded(i, calendarID, userPrefs) {
+			continue
 		}
-	}
-
-	for _, i := range items {
-		if i.Start.DateTime != "" &&
-			!eventExcludedByPrefs(i.Summary, userPrefs) &&
-			eventHasAcceptableResponse(i, userPrefs.ResponseState) {
+		if travel := travelTime(i, locations, userPrefs); travel > 0 {
+			debugLog("Event '%v' is %v away\n", i.Summary, travel)
+			fetched.travel[i.Id] = travel
+		}
+		if isAllDayEvent(i) {
+			fetched.allDay = append(fetched.allDay, i)
+		} else {
 			events = append(events, i)
-			if len(events) == 2 || (len(events) == 1 && !userPrefs.MultiEvent) {
-				break
-			}
 		}
 	}
-	debugLog("nextEvent returning %d events\n", len(events))
+	debugLog("filterEvents returning %d events\n", len(events))
 	return events
 }
 
-func blinkStateForDelta(delta float64) CalendarState {
-	blinkState := Black
-	switch {
-	case delta < -1:
-		blinkState = Blue
-	case delta < 0:
-		blinkState = BlueFlash
-	case delta < 2:
-		blinkState = FastRedFlash
-	case delta < 5:
-		blinkState = RedFlash
-	case delta < 10:
-		blinkState = Red
-	case delta < 30:
-		blinkState = Yellow
-	case delta < 60:
-		blinkState = Green
+// nextEvent returns the events to show at the given time: the first event that hasn't ended
+// yet, and with multiEvent, the one after it.
+func nextEvent(items []*calendar.Event, now time.Time, userPrefs *UserPrefs) []*calendar.Event {
+	var events []*calendar.Event
+	for _, i := range items {
+		if !eventEndTime(i).After(now) {
+			continue
+		}
+		events = append(events, i)
+		if len(events) == 2 || (len(events) == 1 && !userPrefs.MultiEvent) {
+			break
+		}
 	}
-	return blinkState
+	verboseLog("nextEvent returning %d events\n", len(events))
+	return events
 }
 
-func blinkStateForEvent(next []*calendar.Event, priority int) CalendarState {
+// blinkStateForEvent returns the state to show for the next events, each on the ladder and in the
+// scheme its rules or profile pick.
+func blinkStateForEvent(next []*calendar.Event, cache *EventCache, now time.Time, userPrefs *UserPrefs) CalendarState {
+	priority := userPrefs.PriorityFlashSide
 	blinkState := Black
 	for i, event := range next {
 		startTime, err := time.Parse(time.RFC3339, event.Start.DateTime)
 		if err == nil {
-			delta := -time.Since(startTime).Minutes()
+			delta := leaveByDelta(startTime.Sub(now), cache.Travel[event.Id])
+			profile := profileByName(cache.Profiles[event.Id], userPrefs)
+			ladder, scheme, rule := styleForEvent(event, cache.Calendars[event.Id], profile, userPrefs)
+			state := ladderState(ladder, event, delta, scheme, userPrefs)
+			if userPrefs.Unanswered != UnansweredNormal && isUnanswered(event) {
+				state = unansweredState(state, userPrefs.Unanswered)
+			}
 			if i == 0 {
-				blinkState = blinkStateForDelta(delta)
+				blinkState = state
 			} else {
-				secondary := blinkStateForDelta(delta)
-				if secondary != Black {
-					blinkState = CombineStates(blinkState, secondary)
+				if state != Black {
+					blinkState = CombineStates(blinkState, state)
 				}
+				// Set priority.  If priority is set, and the other light is flashing but the priority one isn't, swap them.
 				if (priority == 1 && blinkState.primaryFlash == 0 && blinkState.secondaryFlash > 0) ||
 					(priority == 2 && blinkState.primaryFlash > 0 && blinkState.secondaryFlash == 0) {
-					debugLog("Swapping")
+					verboseLog("Swapping\n")
 					blinkState = SwapState(blinkState)
 				}
 			}
-			debugLog("Event %v, time %v, delta %v, state %v\n", event.Summary, startTime, delta, blinkState.Name)
-			// Set priority.  If priority is set, and the other light is flashing but the priority one isn't, swap them.
-
+			if rule != "" {
+				verboseLog("Event %v styled by %v\n", event.Summary, rule)
+			}
+			verboseLog("Event %v, time %v, delta %v, state %v\n", event.Summary, startTime, delta.Minutes(), blinkState.Name)
 		} else {
 			errorLog("%v\n", err)
 			break
@@ -139,86 +174,192 @@ func blinkStateForEvent(next []*calendar.Event, priority int) CalendarState {
 	return blinkState
 }
 
-func fetchEvents(now time.Time, srv *calendar.Service, userPrefs *UserPrefs) ([]*calendar.Event, error) {
-	start := now.Format(time.RFC3339)
-	endTime := now.Add(2 * time.Hour)
-	end := endTime.Format(time.RFC3339)
+// CalendarLister lists the events in a time window for a Google calendar.
+type CalendarLister interface {
+	List(calendarID string, start time.Time, end time.Time) ([]*calendar.Event, error)
+}
+
+// googleLister lists events with a fresh Events.List call every time.
+type googleLister struct {
+	srv       *calendar.Service
+	maxEvents int
+}
+
+func (lister googleLister) List(calendarID string, start time.Time, end time.Time) ([]*calendar.Event, error) {
+	call := lister.srv.Events.List(calendarID).ShowDeleted(false).
+		SingleEvents(true).TimeMin(start.Format(time.RFC3339)).TimeMax(end.Format(time.RFC3339)).OrderBy("startTime").
+		EventTypes(calendarEventTypes...)
+	items, _, err := listAllPages(call, calendarID, lister.maxEvents)
+	return items, err
+}
+
+// The event fields calblink uses.  Only these are requested, to keep responses small.
+const eventFields = "id,status,summary,description,location,start,end,created,eventType,recurringEventId," +
+	"attendees(email,displayName,self,resource,responseStatus),organizer(email,self),visibility,transparency,hangoutLink," +
+	"conferenceData(entryPoints(entryPointType,uri)),reminders,workingLocationProperties," +
+	"outOfOfficeProperties,focusTimeProperties"
+
+// listEventsPageSize is how many events are requested in each page of results.
+const listEventsPageSize = 250
+
+// listAllPages runs an Events.List call, following page tokens until every event has been read,
+// and returns the events and the sync token from the last page.  If maxEvents is positive and a
+// calendar has more events than that, the rest are dropped with a warning, and there is no sync
+// token.  Only cap calls ordered by start time, so that it's the latest events that are dropped.
+func listAllPages(call *calendar.EventsListCall, calendarID string, maxEvents int) ([]*calendar.Event, string, error) {
+	call = call.MaxResults(listEventsPageSize).
+		Fields(googleapi.Field("nextPageToken,nextSyncToken,defaultReminders,items(" + eventFields + ")"))
+	var items []*calendar.Event
+	pageToken := ""
+	for {
+		if pageToken != "" {
+			call = call.PageToken(pageToken)
+		}
+		events, err := call.Do()
+		if err != nil {
+			return nil, "", err
+		}
+		applyDefaultReminders(events.Items, events.DefaultReminders)
+		items = append(items, events.Items...)
+		if maxEvents > 0 && len(items) >= maxEvents {
+			if len(items) > maxEvents || events.NextPageToken != "" {
+				errorLog("Calendar %v has more than %d events in the window, ignoring the rest\n", calendarID, maxEvents)
+			}
+			return items[:maxEvents], "", nil
+		}
+		if events.NextPageToken == "" {
+			return items, events.NextSyncToken, nil
+		}
+		pageToken = events.NextPageToken
+	}
+}
+
+// The event types calblink requests from Google Calendar.
+var calendarEventTypes = []string{"default", "focusTime", "outOfOffice", "workingLocation"}
+
+// fetchedEvents holds the events from every calendar and source before they are filtered.
+type fetchedEvents struct {
+	items []*calendar.Event
+	// The calendar ID or source name each event came from, by event ID.
+	calendars map[string]string
+	// The working locations set on each calendar.
+	locations map[string]*calendarLocations
+	// The name of the profile each event is shown with, by event ID.
+	profiles map[string]string
+	// How long it takes to get to each event, by event ID, for the events that are elsewhere.
+	travel map[string]time.Duration
+	// The all-day events to show, which are kept apart from the timed events.
+	allDay      []*calendar.Event
+	outOfOffice []OutOfOffice
+	// When the user is back, if they are out of office on every calendar for long enough to idle.
+	awayUntil time.Time
+}
+
+// fetchEvents returns the events in the lookahead window that the user preferences allow to
+// be shown.
+func fetchEvents(now time.Time, lister CalendarLister, sources []EventSource, userPrefs *UserPrefs) (*fetchedEvents, error) {
+	fetched, err := gatherEvents(now, lister, sources, userPrefs)
+	if err != nil {
+		return nil, err
+	}
+	fetched.items = filterEvents(fetched, userPrefs)
+	return fetched, nil
+}
+
+// makeOutOfOffice converts an out of office event into the period it covers.  OOO events don't
+// use an empty start time to indicate an all-day event, so the period is just the event's times,
+// which may run well beyond the lookahead window.
+func makeOutOfOffice(event *calendar.Event, calendarID string) OutOfOffice {
+	period := OutOfOffice{Calendar: calendarID, Start: eventStartTime(event), End: eventEndTime(event)}
+	debugLog("Out of office on calendar %v from %v to %v\n", calendarID, period.Start, period.End)
+	return period
+}
+
+// fetchWindow returns how far ahead to fetch events: the lookahead, extended with useReminders
+// so that reminders further ahead can fire, and by the longest travel time, so that warnings for
+// meetings elsewhere start as early as they should.
+func fetchWindow(userPrefs *UserPrefs) time.Duration {
+	window := time.Duration(userPrefs.Lookahead) * time.Minute
+	if userPrefs.UseReminders {
+		if reminders := time.Duration(userPrefs.ReminderLookahead) * time.Minute; reminders > window {
+			window = reminders
+		}
+	}
+	return window + longestTravelTime(userPrefs)
+}
+
+// gatherEvents reads the events in the lookahead window from every calendar and source,
+// along with the working locations and out of office periods found.  A source that can't be
+// read is skipped, unless every source failed and there are no Google calendars.
+func gatherEvents(now time.Time, lister CalendarLister, sources []EventSource, userPrefs *UserPrefs) (*fetchedEvents, error) {
+	endTime := now.Add(fetchWindow(userPrefs))
 	var allEvents []*calendar.Event
-	locations := make([]WorkSite, 0)
-	for _, calendar := range userPrefs.Calendars {
-		var locationCreated time.Time
-		var location WorkSite
-		skip := false
-		events, err := srv.Events.List(calendar).ShowDeleted(false).
-			SingleEvents(true).TimeMin(start).TimeMax(end).OrderBy("startTime").
-			EventTypes("default", "focusTime", "outOfOffice", "workingLocation").Do()
+	eventCalendars := make(map[string]string)
+	locations := make(map[string]*calendarLocations)
+	var outOfOffice []OutOfOffice
+	// The calendars that can be out of office, in the order they were read.
+	var calendarIDs []string
+	for _, calendarID := range userPrefs.Calendars {
+		var meetings []*calendar.Event
+		items, err := lister.List(calendarID, now, endTime)
 		if err != nil {
 			return nil, err
 		}
-		for _, event := range events.Items {
+		if canBeOutOfOffice(calendarID, items) {
+			calendarIDs = append(calendarIDs, calendarID)
+		}
+		for _, event := range items {
 			if event.EventType == "workingLocation" {
-				// The calendar event can return three or more working locations:
-				// 1. The recurring one for the given day of the week
-				// 2. The override for that particular day
-				// 3. Any time overrides that are currently set for specific hours of the day.
-				//
-				// The logic here isn't complicated enough to manage matching events to
-				// a location, so instead, gather the latest all-date event and all
-				// time overrides.  Any event that matches one of those will have an
-				// acceptable location.
-				isAllDay := (event.Start.DateTime == "")
-				thisCreated, err := time.Parse(time.RFC3339, event.Created)
-				if err != nil || (thisCreated.Before(locationCreated) && isAllDay) {
-					debugLog("Skipping location event %v because it's before the current one\n", event.Summary)
-					continue
-				}
-				locationProperties := event.WorkingLocationProperties
-				locationType := makeWorkSiteType(locationProperties.Type)
-				locationString := ""
-				switch locationType {
-				case WorkSiteOffice:
-					locationString = locationProperties.OfficeLocation.Label
-				case WorkSiteCustom:
-					locationString = locationProperties.CustomLocation.Label
+				if locations[calendarID] == nil {
+					locations[calendarID] = newCalendarLocations()
 				}
-				if isAllDay {
-					location = WorkSite{SiteType: locationType, Name: locationString}
-					locationCreated = thisCreated
-				} else {
-					debugLog("Location Override detected: calendar %v, location %v", calendar, location)
-					locations = append(locations, WorkSite{SiteType: locationType, Name: locationString})
-				}
-				debugLog("Location detected: calendar %v, location %v\n", calendar, location)
+				locations[calendarID].add(event, calendarID)
 			} else if event.EventType == "outOfOffice" {
-				// OOO events don't use an empty start time to indicate an all-day event.
-				// Instead, check if the start is before our current window and the end
-				// is after it ends, and if so, skip this entire calendar.
-				eventStart, err1 := time.Parse(time.RFC3339, event.Start.DateTime)
-				eventEnd, err2 := time.Parse(time.RFC3339, event.End.DateTime)
-				if err1 != nil || err2 != nil {
-					debugLog("Skipping event %v because of time parse errors: %v, %v\n", event.Summary, err1, err2)
-				}
-				if eventStart.Before(now) && eventEnd.After(endTime) {
-					debugLog("Skipping calendar %v due to OOO\n", calendar)
-					skip = true
-					break
-				} else {
-					debugLog("Not applying OOO event %v to calendar %v\n", event, calendar)
-				}
+				outOfOffice = append(outOfOffice, makeOutOfOffice(event, calendarID))
+			} else {
+				meetings = append(meetings, event)
+			}
+		}
+		for _, item := range meetings {
+			if _, ok := eventCalendars[item.Id]; !ok {
+				eventCalendars[item.Id] = calendarID
 			}
 		}
-		if !skip {
-			if !locationCreated.IsZero() {
-				debugLog("Adding final location %v\n", location)
-				locations = append(locations, location)
-				debugLog("Locations: %v\n", locations)
+		allEvents = append(allEvents, meetings...)
+	}
+	var sourceErr error
+	failedSources := 0
+	for _, source := range sources {
+		events, err := source.Events(now, endTime)
+		if err != nil {
+			// Show what the other calendars have rather than losing them all to one flaky feed.
+			errorLog("Unable to read events from %v, skipping it: %v\n", source.Name(), err)
+			sourceErr = err
+			failedSources++
+			continue
+		}
+		if canBeOutOfOffice(source.Name(), events) {
+			calendarIDs = append(calendarIDs, source.Name())
+		}
+		for _, event := range events {
+			if event.EventType == "outOfOffice" {
+				outOfOffice = append(outOfOffice, makeOutOfOffice(event, sourceCalendar(source, event)))
+				continue
 			}
-			allEvents = append(allEvents, events.Items...)
+			if _, ok := eventCalendars[event.Id]; !ok {
+				eventCalendars[event.Id] = sourceCalendar(source, event)
+			}
+			allEvents = append(allEvents, event)
 		}
 	}
-	if len(userPrefs.Calendars) > 1 {
-		// Filter out copies of the same event, or ones with times that don't parse.
+	if len(userPrefs.Calendars) == 0 && failedSources > 0 && failedSources == len(sources) {
+		return nil, sourceErr
+	}
+	if len(userPrefs.Calendars)+len(sources) > 1 {
+		// Filter out copies of the same event, or ones with times that don't parse.  All-day
+		// events are kept apart, since they can't be sorted with the timed events.
 		var filtered []*calendar.Event
+		var allDay []*calendar.Event
 		seen := make(map[string]bool)
 		for _, event := range allEvents {
 			if seen[event.Id] {
@@ -229,12 +370,12 @@ func fetchEvents(now time.Time, srv *calendar.Service, userPrefs *UserPrefs) ([]
 				debugLog("Skipping working location/OOO event %v\n", event.Summary)
 				continue
 			}
+			seen[event.Id] = true
 			if event.Start.DateTime == "" {
-				debugLog("Skipping all-day event %v\n", event.Summary)
+				allDay = append(allDay, event)
 				continue
 			}
 			filtered = append(filtered, event)
-			seen[event.Id] = true
 		}
 		sort.SliceStable(filtered, func(i, j int) bool {
 			t1, err1 := time.Parse(time.RFC3339, filtered[i].Start.DateTime)
@@ -248,7 +389,16 @@ func fetchEvents(now time.Time, srv *calendar.Service, userPrefs *UserPrefs) ([]
 			}
 			return t1.Before(t2)
 		})
-		allEvents = filtered
+		allEvents = append(filtered, allDay...)
 	}
-	return nextEvent(allEvents, locations, userPrefs), nil
+	idleThreshold := time.Duration(userPrefs.OutOfOfficeIdle) * time.Hour
+	return &fetchedEvents{
+		items:       allEvents,
+		calendars:   eventCalendars,
+		profiles:    make(map[string]string),
+		travel:      make(map[string]time.Duration),
+		locations:   locations,
+		outOfOffice: outOfOffice,
+		awayUntil:   awayUntil(outOfOffice, calendarIDs, now, idleThreshold),
+	}, nil
 }
@@ -0,0 +1,127 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages the filter and response settings for each calendar.
+
+package main
+
+import (
+	"fmt"
+)
+
+// Calendars with their own settings are given in the config file as an array of tables:
+//   [[calendar]]
+//   id = "team@group.calendar.google.com"
+//   responseState = "all"
+//   excludes = ["Team lunch"]
+//   excludePrefixes = ["FYI:"]
+//   workingLocations = ["office:HQ"]
+//
+// Each setting that is left out falls back to the global one.  The calendars are watched as if
+// they were in the calendars list; for another account's calendar, use its account name too, as
+// in "personal/primary".  They are added to the calendars list, or to the default primary
+// calendar if there isn't one.  calendar can't be both a single ID and a list of tables, so this
+// form can't be used with calendar = "id" in the same file.
+
+// CalendarSettings holds the settings for one calendar.  Settings that are nil or empty use the
+// global ones.
+type CalendarSettings struct {
+	ID               string
+	Excludes         map[string]bool
+	ExcludePrefixes  []string
+	ResponseState    ResponseState
+	WorkingLocations []WorkSite
+}
+
+// Struct used for decoding a calendar's settings from TOML.
+type tomlCalendar struct {
+	ID               string
+	Excludes         []string
+	ExcludePrefixes  []string
+	ResponseState    string
+	WorkingLocations []string
+}
+
+func makeCalendarSettings(layout tomlCalendar) (*CalendarSettings, error) {
+	if layout.ID == "" {
+		return nil, fmt.Errorf("calendar without an id")
+	}
+	settings := &CalendarSettings{ID: layout.ID, ExcludePrefixes: layout.ExcludePrefixes}
+	// An explicitly empty list clears the global one, so only nil falls back to it.
+	if layout.Excludes != nil {
+		settings.Excludes = make(map[string]bool)
+		for _, item := range layout.Excludes {
+			settings.Excludes[item] = true
+		}
+	}
+	if layout.ResponseState != "" {
+		settings.ResponseState = ResponseState(layout.ResponseState)
+		if !settings.ResponseState.isValidState() {
+			return nil, fmt.Errorf("calendar %v: invalid response state %v", layout.ID, layout.ResponseState)
+		}
+	}
+	if layout.WorkingLocations != nil {
+		settings.WorkingLocations = []WorkSite{}
+		for _, location := range layout.WorkingLocations {
+			settings.WorkingLocations = append(settings.WorkingLocations, makeWorkSite(location))
+		}
+	}
+	return settings, nil
+}
+
+// makeCalendarSettingsMap converts the calendar settings read from the config file into a map
+// by calendar ID.
+func makeCalendarSettingsMap(layouts []tomlCalendar) (map[string]*CalendarSettings, error) {
+	settings := make(map[string]*CalendarSettings)
+	for _, layout := range layouts {
+		calendarSettings, err := makeCalendarSettings(layout)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := settings[layout.ID]; ok {
+			return nil, fmt.Errorf("calendar %v is listed twice", layout.ID)
+		}
+		settings[layout.ID] = calendarSettings
+	}
+	return settings, nil
+}
+
+// settingsFor returns the settings to use for a calendar or source, with the global settings
+// filled in where the calendar doesn't have its own.
+func settingsFor(calendarID string, userPrefs *UserPrefs) CalendarSettings {
+	settings := CalendarSettings{
+		ID:               calendarID,
+		Excludes:         userPrefs.Excludes,
+		ExcludePrefixes:  userPrefs.ExcludePrefixes,
+		ResponseState:    userPrefs.ResponseState,
+		WorkingLocations: userPrefs.WorkingLocations,
+	}
+	own, ok := userPrefs.CalendarSettings[calendarID]
+	if !ok {
+		return settings
+	}
+	if own.Excludes != nil {
+		settings.Excludes = own.Excludes
+	}
+	if own.ExcludePrefixes != nil {
+		settings.ExcludePrefixes = own.ExcludePrefixes
+	}
+	if own.ResponseState != "" {
+		settings.ResponseState = own.ResponseState
+	}
+	if own.WorkingLocations != nil {
+		settings.WorkingLocations = own.WorkingLocations
+	}
+	return settings
+}
@@ -23,6 +23,8 @@ import (
 	"io/fs"
 	"log"
 	"os"
+	"path/filepath"
+	"sort"
 	"strings"
 	"time"
 
@@ -43,6 +45,65 @@ import (
 //   showDots = true
 //   multiEvent = true
 //   priorityFlashSide = 1
+//   icsCalendars = ["path/to/file.ics", "https://example.com/feed.ics"]
+//   selfEmails = ["me@example.com"]
+//   caldavCalendars = ["https://example.com/dav/calendars/me/personal/"]
+//   caldavUsername = "me"
+//   caldavPasswordFile = "caldav_password"
+//   graphCalendars = ["primary"]
+//   graphClientSecret = "graph_secret.json"
+//   graphEndpoint = "https://graph.microsoft.com/v1.0"
+//   manualEventsFile = "events.toml"
+//   freeBusyCalendars = ["room@resource.calendar.google.com"]
+//   freeBusyScope = true
+//   incrementalSync = true
+//   useReminders = true
+//   reminderLookahead = 1440
+//   pushAddress = "https://calblink.example.com/notify"
+//   pushListenAddress = ":8845"
+//   pushCertFile = "cert.pem"
+//   pushKeyFile = "key.pem"
+//   pushFallbackInterval = 900
+//   stateDirectory = "/path/to/dir"
+//   staleCacheAge = 300
+//   cacheMaxAge = 3600
+//   pollIntervalFar = 300
+//   pollWarningWindow = 3600
+//   maxPollsPerHour = 120
+//   maxPollBackoff = 3600
+//   lookahead = 120
+//   maxEventsPerCalendar = 1000
+//   focusTime = "dnd"
+//   focusTimeColor = "#200040"
+//   outOfOffice = "away"
+//   outOfOfficeColor = "#301800"
+//   outOfOfficeIdle = 12
+//   unanswered = "dim"
+//   invitationDays = 7
+//   invitationInterval = 3600
+//   invitationColor = "#002030"
+//   [[rule]]
+//   title = "^Lunch"
+//   action = "exclude"
+//   [ladder.early]
+//   green = 90
+//   [scheme.calm]
+//   green = "#00ffff"
+//   [[allDay]]
+//   title = "(?i)on call"
+//   color = "#002020"
+//   [meeting.video]
+//   ladder = "early"
+//   [travel]
+//   "Building 42" = 10
+//   [[calendar]]
+//   id = "team@group.calendar.google.com"
+//   responseState = "all"
+//   [account.personal]
+//   calendars = ["primary"]
+//   [profile.home]
+//   locations = ["home"]
+//   ladder = "early"
 //
 // An older JSON format is also supported but you don't want to use it.
 //
@@ -58,6 +119,62 @@ import (
 // DeviceFailureRetries is the number of consecutive failures to initialize the device before the program quits. Default is 10.
 // ShowDots indicates whether to show dots and similar marks to indicate that the program has completed an update cycle.
 // MultiEvent indicates whether to show two events if there are multiple events in the time range.
+// ICSCalendars is a list of iCalendar file paths or URLs to read events from in addition to Google Calendar.
+// SelfEmails is a list of the user's email addresses, used to find the user's response in ICS and CalDAV events.
+// CalDAVCalendars is a list of CalDAV calendar collection URLs to read events from.
+// CalDAVUsername and CalDAVPasswordFile are the basic auth credentials for the CalDAV server.  The password
+//   file must only be readable by its owner.
+// GraphCalendars is a list of Outlook calendar IDs to read through Microsoft Graph; "primary" is the default calendar.
+// GraphClientSecret is the path to the JSON file holding the Azure app registration for Graph.
+// GraphEndpoint overrides the Microsoft Graph API base URL, for testing.
+// FreeBusyCalendars is a list of Google calendar IDs that only share free/busy times; each busy time is shown as
+//   an event titled "Busy".
+// FreeBusyScope only asks for access to free/busy times.  It can't be used with Calendars, and turns off the
+//   default calendar.
+// ManualEventsFile is the path to a file of manually entered events; see manual.go for its format.
+// IncrementalSync keeps a local copy of each Google calendar and only fetches changes on each poll.
+// UseReminders starts the warnings for each event when its earliest popup reminder fires, instead of where the
+//   ladder starts them.  Events are fetched ReminderLookahead minutes ahead (default 1440) if that is longer than
+//   Lookahead; reminders further ahead than both are never seen, and are ignored.
+// PushAddress is the public HTTPS URL that Google Calendar sends change notifications to.  If set, Google calendars
+//   are only fetched again when they change, or every PushFallbackInterval seconds.
+// PushListenAddress is the local address to receive notifications on.  PushCertFile and PushKeyFile enable HTTPS
+//   on it; without them it uses plain HTTP, for use behind a forwarder or proxy.
+// StateDirectory is where calblink keeps state between runs, such as the event cache.
+// StaleCacheAge is how old (in seconds) the last successful fetch can get before the display is marked stale.
+// CacheMaxAge is how old (in seconds) cached events can get before they are no longer shown.
+// PollInterval is how often (in seconds) to poll while the next event is within PollWarningWindow seconds;
+//   otherwise calblink polls every PollIntervalFar seconds.
+// MaxPollsPerHour limits how many fetches are made in any hour; 0 means no limit.
+// MaxPollBackoff is the longest (in seconds) calblink waits between fetches when over the API quota.
+// Lookahead is how far ahead (in minutes) to look for events.  Default is 120.
+// MaxEventsPerCalendar is the most events read from a Google calendar in each fetch; any more are dropped with a
+//   warning.  With IncrementalSync there is only the warning.  0 means no limit.
+// FocusTime is how focus time events are shown: "dnd" (a steady do not disturb color while they are in progress),
+//   "countdown" (like any other meeting) or "ignore".  Default is dnd.
+// FocusTimeColor is the "#rrggbb" color shown during focus time in dnd mode.
+// OutOfOffice is what to show while out of office: "away" (a steady away color) or "off".  Meetings that start
+//   while out of office are never shown.
+// OutOfOfficeColor is the "#rrggbb" color shown while out of office in away mode.
+// OutOfOfficeIdle is how many hours out of office on every calendar makes calblink turn off and only check the
+//   calendar hourly until the user is back.  Only calendars that can be out of office count; see ooo.go.  0
+//   disables this.  Default is 12.
+// Unanswered is how events with a tentative or no response are shown: "normal" (like any other event), "dim"
+//   (at a quarter of the brightness) or "striped" (dim on LED 1).  Default is normal.
+// InvitationDays is how many days ahead to look for invitations that haven't been answered, which are shown as a
+//   slow pulse on LED 2 when nothing else is shown.  0 (the default) turns this off.
+// InvitationInterval is how often (in seconds) to look for unanswered invitations.  Default is 3600.
+// InvitationColor is the "#rrggbb" color of the unanswered invitation pulse.
+// Rules is an ordered list of rules deciding which events are shown, and how; see rules.go for their format.
+// Ladder and Scheme are named ladders of states and color schemes that rules can pick; see ladder.go.
+// AllDay is a list of styles for showing all-day events, which are otherwise ignored; see allday.go.
+// Meeting picks a ladder and scheme for video calls and in-person meetings; see meeting.go.
+// Travel is a table of minutes it takes to get to rooms and locations, which start the warnings earlier; see
+//   travel.go.  Events are fetched further ahead by the longest travel time to cover them.
+// Calendar can also be a list of tables, each giving a calendar's own excludes, excludePrefixes, responseState and
+//   workingLocations; see calendarsettings.go.
+// Account is a set of named Google accounts, each with its own calendars and saved token; see accounts.go.
+// Profile is a set of named profiles that pick how events are shown by working location; see profile.go.
 // userPrefs is a struct that manages the user preferences as set by the config file and command line.
 
 type UserPrefs struct {
@@ -74,6 +191,50 @@ type UserPrefs struct {
 	MultiEvent           bool
 	PriorityFlashSide    int
 	WorkingLocations     []WorkSite
+	ICSCalendars         []string
+	SelfEmails           []string
+	CalDAVCalendars      []string
+	CalDAVUsername       string
+	CalDAVPasswordFile   string
+	GraphCalendars       []string
+	FreeBusyCalendars    []string
+	FreeBusyScope        bool
+	GraphClientSecret    string
+	GraphEndpoint        string
+	ManualEventsFile     string
+	IncrementalSync      bool
+	UseReminders         bool
+	ReminderLookahead    int
+	PushAddress          string
+	PushListenAddress    string
+	PushCertFile         string
+	PushKeyFile          string
+	PushFallbackInterval int
+	StateDirectory       string
+	StaleCacheAge        int
+	CacheMaxAge          int
+	PollIntervalFar      int
+	PollWarningWindow    int
+	MaxPollsPerHour      int
+	MaxPollBackoff       int
+	Lookahead            int
+	MaxEventsPerCalendar int
+	FocusTime            FocusTimeMode
+	FocusTimeState       CalendarState
+	OutOfOffice          OutOfOfficeMode
+	OutOfOfficeState     CalendarState
+	OutOfOfficeIdle      int
+	Unanswered           UnansweredMode
+	InvitationDays       int
+	InvitationInterval   int
+	InvitationState      CalendarState
+	Rules                []*Rule
+	Profiles             map[WorkSite]*Profile
+	Accounts             []*Account
+	CalendarSettings     map[string]*CalendarSettings
+	MeetingStyles        map[MeetingKind]*MeetingStyle
+	Travel               map[string]time.Duration
+	AllDay               []*AllDayStyle
 }
 
 // Struct used for decoding the JSON
@@ -101,7 +262,7 @@ type tomlLayout struct {
 	EndTime              string
 	SkipDays             []string
 	PollInterval         int64
-	Calendar             string
+	Calendar             toml.Primitive
 	Calendars            []string
 	ResponseState        string
 	DeviceFailureRetries int64
@@ -109,6 +270,51 @@ type tomlLayout struct {
 	MultiEvent           bool
 	PriorityFlashSide    int64
 	WorkingLocations     []string
+	ICSCalendars         []string
+	SelfEmails           []string
+	CalDAVCalendars      []string
+	CalDAVUsername       string
+	CalDAVPasswordFile   string
+	GraphCalendars       []string
+	FreeBusyCalendars    []string
+	FreeBusyScope        bool
+	GraphClientSecret    string
+	GraphEndpoint        string
+	ManualEventsFile     string
+	IncrementalSync      bool
+	UseReminders         bool
+	ReminderLookahead    int64
+	PushAddress          string
+	PushListenAddress    string
+	PushCertFile         string
+	PushKeyFile          string
+	PushFallbackInterval int64
+	StateDirectory       string
+	StaleCacheAge        int64
+	CacheMaxAge          int64
+	PollIntervalFar      int64
+	PollWarningWindow    int64
+	MaxPollsPerHour      int64
+	MaxPollBackoff       int64
+	Lookahead            int64
+	MaxEventsPerCalendar int64
+	FocusTime            string
+	FocusTimeColor       string
+	OutOfOffice          string
+	OutOfOfficeColor     string
+	Unanswered           string
+	InvitationDays       int64
+	InvitationInterval   int64
+	InvitationColor      string
+	OutOfOfficeIdle      *int64
+	Rule                 []tomlRule
+	Ladder               map[string]map[string]int64
+	Scheme               map[string]map[string]string
+	Profile              map[string]tomlProfile
+	Account              map[string]tomlAccount
+	Meeting              map[string]tomlMeetingStyle
+	Travel               map[string]int64
+	AllDay               []tomlAllDay
 }
 
 // responseState is an enumerated list of event response states, used to control which events will activate the blink(1).
@@ -222,6 +428,16 @@ func readUserPrefs() *UserPrefs {
 	}
 }
 
+// defaultStateDirectory returns the directory calblink keeps state in if none is configured.
+func defaultStateDirectory() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		debugLog("No user cache directory, not saving state: %v\n", err)
+		return ""
+	}
+	return filepath.Join(dir, "calblink")
+}
+
 func getDefaultPrefs() *UserPrefs {
 	userPrefs := &UserPrefs{}
 	// Set defaults from command line
@@ -230,13 +446,32 @@ func getDefaultPrefs() *UserPrefs {
 	userPrefs.ResponseState = ResponseState(*responseStateFlag)
 	userPrefs.DeviceFailureRetries = *deviceFailureRetriesFlag
 	userPrefs.ShowDots = *showDotsFlag
+	userPrefs.PushListenAddress = ":8845"
+	userPrefs.PushFallbackInterval = 900
+	userPrefs.StateDirectory = defaultStateDirectory()
+	userPrefs.StaleCacheAge = 300
+	userPrefs.CacheMaxAge = 3600
+	userPrefs.PollIntervalFar = 300
+	userPrefs.PollWarningWindow = 3600
+	userPrefs.MaxPollBackoff = 3600
+	userPrefs.Lookahead = 120
+	userPrefs.ReminderLookahead = 1440
+	userPrefs.MaxEventsPerCalendar = 1000
+	userPrefs.FocusTime = FocusTimeDoNotDisturb
+	userPrefs.FocusTimeState = defaultFocusTimeState
+	userPrefs.OutOfOffice = OutOfOfficeAway
+	userPrefs.Unanswered = UnansweredNormal
+	userPrefs.InvitationInterval = 3600
+	userPrefs.InvitationState = makeInvitationState(defaultInvitationColor)
+	userPrefs.OutOfOfficeState = defaultOutOfOfficeState
+	userPrefs.OutOfOfficeIdle = 12
 	return userPrefs
 }
 
 func readTomlPrefs(configFile string) *UserPrefs {
 	prefs := tomlLayout{}
 	userPrefs := getDefaultPrefs()
-	_, err := toml.DecodeFile(configFile, &prefs)
+	metadata, err := toml.DecodeFile(configFile, &prefs)
 	debugLog("Decoded TOML: %v\n", prefs)
 	if err != nil {
 		log.Fatalf("Unable to parse config file %v", err)
@@ -273,12 +508,57 @@ func readTomlPrefs(configFile string) *UserPrefs {
 			log.Fatalf("Invalid day in skipdays: %v", day)
 		}
 	}
-	if prefs.Calendar != "" {
-		userPrefs.Calendars = []string{prefs.Calendar}
+	var calendarLayouts []tomlCalendar
+	switch metadata.Type("calendar") {
+	case "String":
+		var calendarID string
+		if err := metadata.PrimitiveDecode(prefs.Calendar, &calendarID); err != nil {
+			log.Fatalf("Invalid calendar: %v", err)
+		}
+		userPrefs.Calendars = []string{calendarID}
+	case "ArrayHash":
+		if err := metadata.PrimitiveDecode(prefs.Calendar, &calendarLayouts); err != nil {
+			log.Fatalf("Invalid calendar: %v", err)
+		}
+	case "":
+	default:
+		log.Fatalf("Invalid calendar: should be a calendar ID or [[calendar]] tables")
 	}
-	if len(prefs.Calendars) > 0 {
+	// An explicitly empty calendars list turns off Google Calendar, for people who only use other sources.
+	if len(prefs.Calendars) > 0 || metadata.IsDefined("calendars") {
 		userPrefs.Calendars = prefs.Calendars
+	} else if prefs.FreeBusyScope && !metadata.IsDefined("calendar") {
+		// freeBusyScope can't read the default calendar, so only the free/busy calendars are used.
+		userPrefs.Calendars = nil
 	}
+	accounts, err := makeAccounts(prefs.Account)
+	if err != nil {
+		log.Fatalf("Invalid account: %v", err)
+	}
+	userPrefs.Accounts = accounts
+	calendars := append([]string(nil), userPrefs.Calendars...)
+	for _, account := range accounts {
+		for _, calendarID := range account.Calendars {
+			calendars = append(calendars, accountCalendarID(account.Name, calendarID))
+		}
+	}
+	calendarSettings, err := makeCalendarSettingsMap(calendarLayouts)
+	if err != nil {
+		log.Fatalf("Invalid calendar settings: %v", err)
+	}
+	userPrefs.CalendarSettings = calendarSettings
+	// Calendars with their own settings are watched too, if they aren't listed already.
+	listed := make(map[string]bool)
+	for _, calendarID := range calendars {
+		listed[calendarID] = true
+	}
+	for _, layout := range calendarLayouts {
+		if !listed[layout.ID] {
+			calendars = append(calendars, layout.ID)
+			listed[layout.ID] = true
+		}
+	}
+	userPrefs.Calendars = calendars
 	if prefs.PollInterval != 0 {
 		userPrefs.PollInterval = int(prefs.PollInterval)
 	}
@@ -299,6 +579,137 @@ func readTomlPrefs(configFile string) *UserPrefs {
 	for _, location := range prefs.WorkingLocations {
 		userPrefs.WorkingLocations = append(userPrefs.WorkingLocations, makeWorkSite(location))
 	}
+	userPrefs.ICSCalendars = prefs.ICSCalendars
+	userPrefs.SelfEmails = prefs.SelfEmails
+	userPrefs.CalDAVCalendars = prefs.CalDAVCalendars
+	userPrefs.CalDAVUsername = prefs.CalDAVUsername
+	userPrefs.CalDAVPasswordFile = prefs.CalDAVPasswordFile
+	userPrefs.GraphCalendars = prefs.GraphCalendars
+	userPrefs.GraphClientSecret = prefs.GraphClientSecret
+	if userPrefs.GraphClientSecret == "" {
+		userPrefs.GraphClientSecret = "graph_secret.json"
+	}
+	userPrefs.GraphEndpoint = prefs.GraphEndpoint
+	userPrefs.FreeBusyCalendars = prefs.FreeBusyCalendars
+	userPrefs.FreeBusyScope = prefs.FreeBusyScope
+	if userPrefs.FreeBusyScope && len(userPrefs.Calendars) > 0 {
+		log.Fatalf("freeBusyScope can't read calendars; it can only be used with freeBusyCalendars")
+	}
+	userPrefs.ManualEventsFile = prefs.ManualEventsFile
+	userPrefs.IncrementalSync = prefs.IncrementalSync
+	userPrefs.UseReminders = prefs.UseReminders
+	if prefs.ReminderLookahead != 0 {
+		userPrefs.ReminderLookahead = int(prefs.ReminderLookahead)
+	}
+	userPrefs.PushAddress = prefs.PushAddress
+	if prefs.PushListenAddress != "" {
+		userPrefs.PushListenAddress = prefs.PushListenAddress
+	}
+	userPrefs.PushCertFile = prefs.PushCertFile
+	userPrefs.PushKeyFile = prefs.PushKeyFile
+	if prefs.PushFallbackInterval != 0 {
+		userPrefs.PushFallbackInterval = int(prefs.PushFallbackInterval)
+	}
+	if prefs.StateDirectory != "" {
+		userPrefs.StateDirectory = prefs.StateDirectory
+	}
+	if prefs.StaleCacheAge != 0 {
+		userPrefs.StaleCacheAge = int(prefs.StaleCacheAge)
+	}
+	if prefs.CacheMaxAge != 0 {
+		userPrefs.CacheMaxAge = int(prefs.CacheMaxAge)
+	}
+	if prefs.PollIntervalFar != 0 {
+		userPrefs.PollIntervalFar = int(prefs.PollIntervalFar)
+	}
+	if prefs.PollWarningWindow != 0 {
+		userPrefs.PollWarningWindow = int(prefs.PollWarningWindow)
+	}
+	if prefs.MaxPollsPerHour != 0 {
+		userPrefs.MaxPollsPerHour = int(prefs.MaxPollsPerHour)
+	}
+	if prefs.MaxPollBackoff != 0 {
+		userPrefs.MaxPollBackoff = int(prefs.MaxPollBackoff)
+	}
+	if prefs.Lookahead != 0 {
+		userPrefs.Lookahead = int(prefs.Lookahead)
+	}
+	if prefs.MaxEventsPerCalendar != 0 {
+		userPrefs.MaxEventsPerCalendar = int(prefs.MaxEventsPerCalendar)
+	}
+	if prefs.FocusTime != "" {
+		userPrefs.FocusTime = FocusTimeMode(prefs.FocusTime)
+		if !userPrefs.FocusTime.isValidMode() {
+			log.Fatalf("Invalid focus time mode %v", prefs.FocusTime)
+		}
+	}
+	if prefs.FocusTimeColor != "" {
+		color, err := parseColor(prefs.FocusTimeColor)
+		if err != nil {
+			log.Fatalf("Invalid focus time color: %v", err)
+		}
+		userPrefs.FocusTimeState = CalendarState{Name: "Focus Time", primary: color, secondary: color}
+	}
+	if prefs.Unanswered != "" {
+		userPrefs.Unanswered = UnansweredMode(prefs.Unanswered)
+		if !userPrefs.Unanswered.isValidMode() {
+			log.Fatalf("Invalid unanswered mode %v", prefs.Unanswered)
+		}
+	}
+	if prefs.InvitationDays != 0 {
+		userPrefs.InvitationDays = int(prefs.InvitationDays)
+	}
+	if prefs.InvitationInterval != 0 {
+		userPrefs.InvitationInterval = int(prefs.InvitationInterval)
+	}
+	if prefs.InvitationColor != "" {
+		color, err := parseColor(prefs.InvitationColor)
+		if err != nil {
+			log.Fatalf("Invalid invitation color: %v", err)
+		}
+		userPrefs.InvitationState = makeInvitationState(color)
+	}
+	if prefs.OutOfOffice != "" {
+		userPrefs.OutOfOffice = OutOfOfficeMode(prefs.OutOfOffice)
+		if !userPrefs.OutOfOffice.isValidMode() {
+			log.Fatalf("Invalid out of office mode %v", prefs.OutOfOffice)
+		}
+	}
+	if prefs.OutOfOfficeColor != "" {
+		color, err := parseColor(prefs.OutOfOfficeColor)
+		if err != nil {
+			log.Fatalf("Invalid out of office color: %v", err)
+		}
+		userPrefs.OutOfOfficeState = CalendarState{Name: "Away", primary: color, secondary: color}
+	}
+	if prefs.OutOfOfficeIdle != nil {
+		userPrefs.OutOfOfficeIdle = int(*prefs.OutOfOfficeIdle)
+	}
+	ladders, schemes, err := makeLadders(prefs.Ladder, prefs.Scheme)
+	if err != nil {
+		log.Fatalf("Invalid ladder or scheme: %v", err)
+	}
+	rules, err := makeRules(prefs.Rule, ladders, schemes)
+	if err != nil {
+		log.Fatalf("Invalid rule: %v", err)
+	}
+	userPrefs.Rules = rules
+	profiles, err := makeProfiles(prefs.Profile, ladders, schemes)
+	if err != nil {
+		log.Fatalf("Invalid profile: %v", err)
+	}
+	userPrefs.Profiles = profiles
+	meetingStyles, err := makeMeetingStyles(prefs.Meeting, ladders, schemes)
+	if err != nil {
+		log.Fatalf("Invalid meeting style: %v", err)
+	}
+	userPrefs.MeetingStyles = meetingStyles
+	userPrefs.Travel = makeTravelTimes(prefs.Travel)
+	allDay, err := makeAllDayStyles(prefs.AllDay)
+	if err != nil {
+		log.Fatalf("Invalid all-day style: %v", err)
+	}
+	userPrefs.AllDay = allDay
 	debugLog("User prefs: %v\n", userPrefs)
 	return userPrefs
 }
@@ -386,15 +797,80 @@ func readJsonPrefs(configFile string) *UserPrefs {
 }
 
 func printStartInfo(userPrefs *UserPrefs) {
-	fmt.Printf("Running with %v second intervals\n", userPrefs.PollInterval)
+	fmt.Printf("Running with %v second intervals, or %v seconds with no event in the next %v minutes\n",
+		userPrefs.PollInterval, userPrefs.PollIntervalFar, userPrefs.PollWarningWindow/60)
+	fmt.Printf("Looking %v minutes ahead\n", userPrefs.Lookahead)
+	if userPrefs.UseReminders {
+		fmt.Printf("Fetching %v minutes ahead for reminders; longer reminders are ignored\n", int(fetchWindow(userPrefs)/time.Minute))
+	}
+	if userPrefs.FocusTime != FocusTimeDoNotDisturb {
+		fmt.Printf("Focus time mode: %v\n", userPrefs.FocusTime)
+	}
+	if userPrefs.OutOfOffice != OutOfOfficeAway {
+		fmt.Printf("Out of office mode: %v\n", userPrefs.OutOfOffice)
+	}
+	if userPrefs.Unanswered != UnansweredNormal {
+		fmt.Printf("Unanswered events mode: %v\n", userPrefs.Unanswered)
+	}
+	if userPrefs.InvitationDays > 0 {
+		fmt.Printf("Checking for unanswered invitations in the next %v days every %v seconds\n",
+			userPrefs.InvitationDays, userPrefs.InvitationInterval)
+	}
+	if len(userPrefs.Rules) > 0 {
+		fmt.Printf("Filtering events with %d rules\n", len(userPrefs.Rules))
+	}
+	if len(userPrefs.Profiles) > 0 {
+		fmt.Printf("Location profiles:\n")
+		for site, profile := range userPrefs.Profiles {
+			fmt.Printf("   %v %v: %v\n", site.SiteType.toString(), site.Name, profile.Name)
+		}
+	}
+	if userPrefs.MaxPollsPerHour > 0 {
+		fmt.Printf("Polling at most %v times an hour\n", userPrefs.MaxPollsPerHour)
+	}
 	if len(userPrefs.Calendars) == 1 {
 		fmt.Printf("Monitoring calendar ID %v\n", userPrefs.Calendars[0])
-	} else {
+	} else if len(userPrefs.Calendars) > 1 {
 		fmt.Println("Monitoring calendar IDs:")
 		for _, item := range userPrefs.Calendars {
 			fmt.Printf("   %v\n", item)
 		}
 	}
+	if len(userPrefs.ICSCalendars) > 0 {
+		fmt.Println("Monitoring ICS calendars:")
+		for _, item := range userPrefs.ICSCalendars {
+			fmt.Printf("   %v\n", icsFeedName(item))
+		}
+	}
+	if len(userPrefs.CalDAVCalendars) > 0 {
+		fmt.Println("Monitoring CalDAV calendars:")
+		for _, item := range userPrefs.CalDAVCalendars {
+			fmt.Printf("   %v\n", item)
+		}
+	}
+	if userPrefs.ManualEventsFile != "" {
+		fmt.Printf("Reading manual events from %v\n", userPrefs.ManualEventsFile)
+	}
+	if len(userPrefs.GraphCalendars) > 0 {
+		fmt.Println("Monitoring Outlook calendars:")
+		for _, item := range userPrefs.GraphCalendars {
+			fmt.Printf("   %v\n", item)
+		}
+	}
+	var settingsIDs []string
+	for calendarID := range userPrefs.CalendarSettings {
+		settingsIDs = append(settingsIDs, calendarID)
+	}
+	sort.Strings(settingsIDs)
+	for _, calendarID := range settingsIDs {
+		fmt.Printf("Calendar %v has its own settings\n", calendarID)
+	}
+	if len(userPrefs.FreeBusyCalendars) > 0 {
+		fmt.Println("Monitoring free/busy for calendars:")
+		for _, item := range userPrefs.FreeBusyCalendars {
+			fmt.Printf("   %v\n", item)
+		}
+	}
 	switch userPrefs.ResponseState {
 	case ResponseStateAll:
 		fmt.Println("All events shown, regardless of accepted/rejected status.")
@@ -456,4 +932,13 @@ func printStartInfo(userPrefs *UserPrefs) {
 	if userPrefs.MultiEvent {
 		fmt.Println("Multievent is active.")
 	}
+	if userPrefs.IncrementalSync {
+		fmt.Println("Incremental sync is active.")
+	}
+	if userPrefs.PushAddress != "" {
+		fmt.Printf("Push notifications sent to %v, listening on %v\n", userPrefs.PushAddress, userPrefs.PushListenAddress)
+	}
+	if userPrefs.StateDirectory != "" {
+		fmt.Printf("Saving state in %v\n", userPrefs.StateDirectory)
+	}
 }
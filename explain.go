@@ -0,0 +1,133 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages the explain command, which shows how the rules treat an event.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// runExplain fetches events as the main loop would, and for each one whose title contains
+// query (or whose ID is query), prints whether it would be shown and why.  It doesn't touch
+// the blink(1).
+func runExplain(userPrefs *UserPrefs, query string) {
+	if query == "" {
+		log.Fatalf("Usage: calblink explain <event title>")
+	}
+	lister, accounts := makeLister(userPrefs)
+	sources := makeEventSources(userPrefs, accounts[""])
+	now := time.Now()
+	fetched, err := gatherEvents(now, lister, sources, userPrefs)
+	if err != nil {
+		log.Fatalf("Unable to fetch events: %v", err)
+	}
+	lowerQuery := strings.ToLower(query)
+	found := false
+	for _, event := range fetched.items {
+		if event.Id != query && !strings.Contains(strings.ToLower(event.Summary), lowerQuery) {
+			continue
+		}
+		found = true
+		explainEvent(event, fetched, userPrefs)
+	}
+	if !found {
+		fmt.Printf("No event matching '%v' in the next %v minutes.\n", query, int(fetchWindow(userPrefs)/time.Minute))
+		fmt.Printf("Working location and out of office events aren't shown as events, so they can't be explained.\n")
+	}
+}
+
+func explainEvent(event *calendar.Event, fetched *fetchedEvents, userPrefs *UserPrefs) {
+	calendarID := fetched.calendars[event.Id]
+	fmt.Printf("Event '%v' (%v) on %v\n", event.Summary, event.Id, calendarID)
+	fmt.Printf("  Time: %v to %v\n", eventStartTime(event).Format(time.RFC1123), eventEndTime(event).Format(time.RFC1123))
+	if isAllDayEvent(event) && allDayStyleFor(event, userPrefs) == nil {
+		if len(userPrefs.AllDay) == 0 {
+			fmt.Printf("  Result: not shown, because it is an all-day event and no allDay styles are set\n")
+		} else {
+			fmt.Printf("  Result: not shown, because it is an all-day event and its title doesn't match any allDay title\n")
+		}
+		return
+	}
+	if isFocusEvent(event) && userPrefs.FocusTime == FocusTimeIgnore {
+		fmt.Printf("  Result: not shown, because focusTime is ignore\n")
+		return
+	}
+	if outOfOfficeAt(fetched.outOfOffice, calendarID, eventStartTime(event)) {
+		fmt.Printf("  Result: not shown, because you are out of office when it starts\n")
+		return
+	}
+	locations := eventLocations(event, calendarID, fetched.locations)
+	if !locationsMatch(locations, calendarID, userPrefs) {
+		fmt.Printf("  Result: not shown, because none of the working locations %v are in workingLocations\n", locations)
+		return
+	}
+	profile := profileForLocations(locations, userPrefs)
+	if profile != nil && profile.Off {
+		fmt.Printf("  Result: not shown, because profile %v is off\n", profile.Name)
+		return
+	}
+	rule, include := applyRules(event, calendarID, userPrefs)
+	switch {
+	case rule == "":
+		fmt.Printf("  Rules: no rule matches, so it is included\n")
+	case include:
+		fmt.Printf("  Rules: included by %v\n", rule)
+	default:
+		fmt.Printf("  Rules: excluded by %v\n", rule)
+		fmt.Printf("  Result: not shown\n")
+		return
+	}
+	if responseState := settingsFor(calendarID, userPrefs).ResponseState; !eventHasAcceptableResponse(event, responseState) {
+		fmt.Printf("  Result: not shown, because your response doesn't match responseState %v\n", responseState)
+		return
+	}
+	if isAllDayEvent(event) {
+		fmt.Printf("  Result: shown as %v all day, unless something else is shown\n", allDayStyleFor(event, userPrefs).State.Name)
+		return
+	}
+	if isFocusEvent(event) && userPrefs.FocusTime == FocusTimeDoNotDisturb {
+		fmt.Printf("  Result: shown as do not disturb while it is in progress, unless a meeting is shown\n")
+		return
+	}
+	ladder, scheme, styleRule := styleForEvent(event, calendarID, profile, userPrefs)
+	if styleRule != "" {
+		schemeName := "default"
+		if scheme != nil {
+			schemeName = scheme.Name
+		}
+		fmt.Printf("  Light: %v ladder and %v scheme, picked by %v\n", ladder.Name, schemeName, styleRule)
+	}
+	if reminder, ok := popupReminder(event); ok && userPrefs.UseReminders {
+		if reminder > 0 {
+			fmt.Printf("  Reminder: %v before, so the warnings start then\n", reminder)
+		} else {
+			fmt.Printf("  Reminder: none, so there are no warnings before it starts\n")
+		}
+	}
+	if travel := travelTime(event, locations, userPrefs); travel > 0 {
+		fmt.Printf("  Travel: %v, so the warnings start that much earlier\n", travel)
+	}
+	if userPrefs.Unanswered != UnansweredNormal && isUnanswered(event) {
+		fmt.Printf("  Result: shown %v, because you haven't accepted it\n", userPrefs.Unanswered)
+		return
+	}
+	fmt.Printf("  Result: shown\n")
+}
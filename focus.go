@@ -0,0 +1,78 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages how focus time events are shown.
+
+package main
+
+import (
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// FocusTimeMode is how focus time events are treated.
+type FocusTimeMode string
+
+const (
+	// Show a steady "do not disturb" color while a focus block is in progress, with no countdown.
+	FocusTimeDoNotDisturb = FocusTimeMode("dnd")
+	// Count down to focus blocks like any other meeting.
+	FocusTimeCountdown = FocusTimeMode("countdown")
+	// Ignore focus blocks entirely.
+	FocusTimeIgnore = FocusTimeMode("ignore")
+)
+
+func (mode FocusTimeMode) isValidMode() bool {
+	switch mode {
+	case FocusTimeDoNotDisturb, FocusTimeCountdown, FocusTimeIgnore:
+		return true
+	}
+	return false
+}
+
+// The default color shown during focus time, a dim purple.
+var defaultFocusTimeState = CalendarState{Name: "Focus Time", primary: DimPurple.primary, secondary: DimPurple.secondary}
+
+func isFocusEvent(event *calendar.Event) bool {
+	return event.EventType == "focusTime"
+}
+
+// splitFocusEvents separates focus blocks from the events that count down.  Focus blocks only
+// count down in countdown mode.
+func splitFocusEvents(events []*calendar.Event, userPrefs *UserPrefs) ([]*calendar.Event, []*calendar.Event) {
+	if userPrefs.FocusTime == FocusTimeCountdown {
+		return events, nil
+	}
+	var meetings []*calendar.Event
+	var focus []*calendar.Event
+	for _, event := range events {
+		if isFocusEvent(event) {
+			focus = append(focus, event)
+		} else {
+			meetings = append(meetings, event)
+		}
+	}
+	return meetings, focus
+}
+
+// inFocusTime returns true if one of the focus blocks is in progress.
+func inFocusTime(focus []*calendar.Event, now time.Time) bool {
+	for _, event := range focus {
+		if !eventStartTime(event).After(now) && eventEndTime(event).After(now) {
+			return true
+		}
+	}
+	return false
+}
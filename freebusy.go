@@ -0,0 +1,95 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages reading Google calendars that only share their free/busy times.
+
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// The title given to the pseudo-events for busy times, which rules can match.
+const freeBusyTitle = "Busy"
+
+// FreeBusySource reads the busy times of Google calendars with Freebusy.Query, for calendars
+// such as colleagues' or rooms where the events themselves can't be seen.  All the calendars
+// are read with a single query.  Each busy interval becomes an event titled "Busy", so it
+// counts down like any other event.
+type FreeBusySource struct {
+	srv         *calendar.Service
+	calendarIDs []string
+	// The calendar each event from the last query came from.
+	eventCalendars map[string]string
+}
+
+func NewFreeBusySource(srv *calendar.Service, calendarIDs []string) *FreeBusySource {
+	return &FreeBusySource{srv: srv, calendarIDs: calendarIDs, eventCalendars: make(map[string]string)}
+}
+
+func (source *FreeBusySource) Name() string {
+	return "free/busy calendars"
+}
+
+// EventCalendar returns the calendar ID an event came from, so rules can match it like a
+// Google calendar.
+func (source *FreeBusySource) EventCalendar(event *calendar.Event) string {
+	return source.eventCalendars[event.Id]
+}
+
+func (source *FreeBusySource) Events(start time.Time, end time.Time) ([]*calendar.Event, error) {
+	request := &calendar.FreeBusyRequest{
+		TimeMin: start.Format(time.RFC3339),
+		TimeMax: end.Format(time.RFC3339),
+	}
+	for _, calendarID := range source.calendarIDs {
+		request.Items = append(request.Items, &calendar.FreeBusyRequestItem{Id: calendarID})
+	}
+	response, err := source.srv.Freebusy.Query(request).Do()
+	if err != nil {
+		return nil, fmt.Errorf("unable to query free/busy: %v", err)
+	}
+	eventCalendars := make(map[string]string)
+	var events []*calendar.Event
+	for _, calendarID := range source.calendarIDs {
+		busy, ok := response.Calendars[calendarID]
+		if !ok {
+			errorLog("No free/busy returned for %v\n", calendarID)
+			continue
+		}
+		// Errors such as notFound come back per calendar rather than failing the whole query, so
+		// only that calendar is skipped.
+		if len(busy.Errors) > 0 {
+			errorLog("Unable to query free/busy for %v: %v\n", calendarID, busy.Errors[0].Reason)
+			continue
+		}
+		for _, period := range busy.Busy {
+			event := &calendar.Event{
+				// Busy times have no IDs, so the start time and calendar make one.
+				Id:      fmt.Sprintf("freebusy-%v-%v", calendarID, period.Start),
+				Summary: freeBusyTitle,
+				Start:   &calendar.EventDateTime{DateTime: period.Start},
+				End:     &calendar.EventDateTime{DateTime: period.End},
+			}
+			eventCalendars[event.Id] = calendarID
+			events = append(events, event)
+		}
+	}
+	source.eventCalendars = eventCalendars
+	sortEventsByStart(events)
+	return events, nil
+}
@@ -0,0 +1,315 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages reading events from Microsoft 365 / Outlook calendars via Microsoft Graph.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/context"
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/microsoft"
+	"google.golang.org/api/calendar/v3"
+)
+
+const defaultGraphEndpoint = "https://graph.microsoft.com/v1.0"
+
+// The fields calblink needs from each Graph event, to keep responses small.
+const graphEventFields = "id,iCalUId,subject,bodyPreview,location,start,end,isAllDay,isCancelled,showAs," +
+	"responseStatus,attendees,organizer,onlineMeeting,isReminderOn,reminderMinutesBeforeStart," +
+	"sensitivity,createdDateTime,lastModifiedDateTime"
+
+// graphCredentials is the layout of the Graph client secret file.  ClientSecret may be left
+// empty for apps registered as public clients.
+type graphCredentials struct {
+	Tenant       string `json:"tenant"`
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+}
+
+// ConnectGraph returns an HTTP client authorized to read the user's calendars through
+// Microsoft Graph.  It follows the same flow as Connect, with its own cached token.
+func ConnectGraph(clientSecretPath string) *http.Client {
+	ctx := context.Background()
+
+	b, err := loadSecureFile(clientSecretPath, "Graph client secret")
+	if err != nil {
+		log.Fatalf("Unable to read Graph client secret file: %v", err)
+	}
+	creds := graphCredentials{}
+	if err := json.Unmarshal(b, &creds); err != nil {
+		log.Fatalf("Unable to parse Graph client secret file: %v", err)
+	}
+	config := &oauth2.Config{
+		ClientID:     creds.ClientID,
+		ClientSecret: creds.ClientSecret,
+		Endpoint:     microsoft.AzureADEndpoint(creds.Tenant),
+		Scopes:       []string{"offline_access", "Calendars.Read"},
+	}
+	client := getClient(ctx, config, "calendar-blink1-graph.json")
+	client.Timeout = 30 * time.Second
+	return client
+}
+
+// Graph API response layouts, covering only the fields calblink uses.
+
+type graphDateTime struct {
+	DateTime string `json:"dateTime"`
+	TimeZone string `json:"timeZone"`
+}
+
+type graphEmailAddress struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+type graphResponseStatus struct {
+	Response string `json:"response"`
+}
+
+type graphAttendee struct {
+	Type         string              `json:"type"`
+	Status       graphResponseStatus `json:"status"`
+	EmailAddress graphEmailAddress   `json:"emailAddress"`
+}
+
+type graphEvent struct {
+	Id                         string              `json:"id"`
+	ICalUId                    string              `json:"iCalUId"`
+	Subject                    string              `json:"subject"`
+	BodyPreview                string              `json:"bodyPreview"`
+	Start                      graphDateTime       `json:"start"`
+	End                        graphDateTime       `json:"end"`
+	IsAllDay                   bool                `json:"isAllDay"`
+	IsCancelled                bool                `json:"isCancelled"`
+	ShowAs                     string              `json:"showAs"`
+	ResponseStatus             graphResponseStatus `json:"responseStatus"`
+	Attendees                  []graphAttendee     `json:"attendees"`
+	Sensitivity                string              `json:"sensitivity"`
+	IsReminderOn               bool                `json:"isReminderOn"`
+	ReminderMinutesBeforeStart int64               `json:"reminderMinutesBeforeStart"`
+	CreatedDateTime            string              `json:"createdDateTime"`
+	LastModifiedDateTime       string              `json:"lastModifiedDateTime"`
+	Location                   struct {
+		DisplayName string `json:"displayName"`
+	} `json:"location"`
+	Organizer struct {
+		EmailAddress graphEmailAddress `json:"emailAddress"`
+	} `json:"organizer"`
+	OnlineMeeting *struct {
+		JoinUrl string `json:"joinUrl"`
+	} `json:"onlineMeeting"`
+}
+
+type graphEventList struct {
+	Value    []graphEvent `json:"value"`
+	NextLink string       `json:"@odata.nextLink"`
+}
+
+// graphResponse maps a Graph response type to a Calendar API response status.
+func graphResponse(response string) string {
+	switch response {
+	case "organizer", "accepted":
+		return "accepted"
+	case "tentativelyAccepted":
+		return "tentative"
+	case "declined":
+		return "declined"
+	}
+	return "needsAction"
+}
+
+// parseGraphDateTime parses a Graph dateTimeTimeZone value.  Graph omits the zone offset and
+// gives the zone separately, normally as UTC since calblink asks for that.
+func parseGraphDateTime(dateTime graphDateTime) (time.Time, error) {
+	loc := time.UTC
+	if dateTime.TimeZone != "" && dateTime.TimeZone != "UTC" {
+		var err error
+		loc, err = time.LoadLocation(dateTime.TimeZone)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unknown time zone %v: %v", dateTime.TimeZone, err)
+		}
+	}
+	return time.ParseInLocation("2006-01-02T15:04:05.9999999", dateTime.DateTime, loc)
+}
+
+// graphEventToCalendarEvent converts a Graph event into a Calendar API event.
+func graphEventToCalendarEvent(item graphEvent) (*calendar.Event, error) {
+	start, err := parseGraphDateTime(item.Start)
+	if err != nil {
+		return nil, err
+	}
+	end, err := parseGraphDateTime(item.End)
+	if err != nil {
+		return nil, err
+	}
+	if item.IsAllDay {
+		// All-day events run from midnight to midnight in the event's zone, so keep the date as given.
+		start = time.Date(start.Year(), start.Month(), start.Day(), 0, 0, 0, 0, time.Local)
+		end = time.Date(end.Year(), end.Month(), end.Day(), 0, 0, 0, 0, time.Local)
+	}
+	event := &calendar.Event{
+		Id:          item.Id,
+		ICalUID:     item.ICalUId,
+		Summary:     item.Subject,
+		Description: item.BodyPreview,
+		Location:    item.Location.DisplayName,
+		Created:     item.CreatedDateTime,
+		Updated:     item.LastModifiedDateTime,
+		Start:       makeEventDateTime(start, item.IsAllDay),
+		End:         makeEventDateTime(end, item.IsAllDay),
+		EventType:   "default",
+		Status:      "confirmed",
+		Organizer: &calendar.EventOrganizer{
+			Email:       strings.ToLower(item.Organizer.EmailAddress.Address),
+			DisplayName: item.Organizer.EmailAddress.Name,
+		},
+	}
+	switch item.ShowAs {
+	case "oof":
+		event.EventType = "outOfOffice"
+	case "free":
+		event.Transparency = "transparent"
+	case "tentative":
+		event.Status = "tentative"
+	}
+	switch item.Sensitivity {
+	case "private", "confidential":
+		event.Visibility = "private"
+	}
+	if item.OnlineMeeting != nil && item.OnlineMeeting.JoinUrl != "" {
+		event.ConferenceData = &calendar.ConferenceData{
+			EntryPoints: []*calendar.EntryPoint{{EntryPointType: "video", Uri: item.OnlineMeeting.JoinUrl}},
+		}
+	}
+	if item.IsReminderOn {
+		event.Reminders = &calendar.EventReminders{
+			Overrides: []*calendar.EventReminder{{Method: "popup", Minutes: item.ReminderMinutesBeforeStart}},
+		}
+	} else {
+		// The reminder was turned off, which useReminders treats as no warnings.
+		event.Reminders = &calendar.EventReminders{}
+	}
+	for _, attendee := range item.Attendees {
+		event.Attendees = append(event.Attendees, &calendar.EventAttendee{
+			Email:          strings.ToLower(attendee.EmailAddress.Address),
+			DisplayName:    attendee.EmailAddress.Name,
+			ResponseStatus: graphResponse(attendee.Status.Response),
+			Optional:       attendee.Type == "optional",
+			Resource:       attendee.Type == "resource",
+		})
+	}
+	// Graph reports the signed-in user's response on the event itself rather than marking an
+	// attendee, so add a self attendee carrying it.  "none" means no response is expected.
+	if item.ResponseStatus.Response != "" && item.ResponseStatus.Response != "none" {
+		event.Organizer.Self = (item.ResponseStatus.Response == "organizer")
+		event.Attendees = append(event.Attendees, &calendar.EventAttendee{
+			Self:           true,
+			Organizer:      event.Organizer.Self,
+			ResponseStatus: graphResponse(item.ResponseStatus.Response),
+		})
+	}
+	return event, nil
+}
+
+// GraphSource reads events from one Outlook calendar through the Graph calendarView API.
+type GraphSource struct {
+	endpoint   string
+	calendarID string
+	client     *http.Client
+}
+
+// NewGraphSource creates a source for the given calendar ID, or "primary" for the user's
+// default calendar.  The endpoint can be pointed at a local server for testing.
+func NewGraphSource(endpoint string, calendarID string, client *http.Client) *GraphSource {
+	if endpoint == "" {
+		endpoint = defaultGraphEndpoint
+	}
+	return &GraphSource{
+		endpoint:   strings.TrimSuffix(endpoint, "/"),
+		calendarID: calendarID,
+		client:     client,
+	}
+}
+
+func (source *GraphSource) Name() string {
+	return "Outlook calendar " + source.calendarID
+}
+
+func (source *GraphSource) Events(start time.Time, end time.Time) ([]*calendar.Event, error) {
+	path := "/me/calendarView"
+	if source.calendarID != "primary" {
+		path = "/me/calendars/" + url.PathEscape(source.calendarID) + "/calendarView"
+	}
+	query := url.Values{}
+	query.Set("startDateTime", start.UTC().Format(time.RFC3339))
+	query.Set("endDateTime", end.UTC().Format(time.RFC3339))
+	query.Set("$select", graphEventFields)
+	query.Set("$orderby", "start/dateTime")
+	query.Set("$top", "50")
+	next := source.endpoint + path + "?" + query.Encode()
+
+	var events []*calendar.Event
+	for next != "" {
+		list, err := source.fetchPage(next)
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range list.Value {
+			if item.IsCancelled {
+				debugLog("Skipping cancelled Graph event %v\n", item.Subject)
+				continue
+			}
+			event, err := graphEventToCalendarEvent(item)
+			if err != nil {
+				debugLog("Skipping Graph event %v: %v\n", item.Subject, err)
+				continue
+			}
+			events = append(events, event)
+		}
+		next = list.NextLink
+	}
+	sortEventsByStart(events)
+	debugLog("Read %d events from %v\n", len(events), source.Name())
+	return events, nil
+}
+
+func (source *GraphSource) fetchPage(pageURL string) (*graphEventList, error) {
+	req, err := http.NewRequest("GET", pageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create Graph request for %v: %v", source.Name(), err)
+	}
+	req.Header.Set("Prefer", `outlook.timezone="UTC"`)
+	resp, err := source.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("unable to query %v: %v", source.Name(), err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to query %v: %v", source.Name(), resp.Status)
+	}
+	list := &graphEventList{}
+	if err := json.NewDecoder(resp.Body).Decode(list); err != nil {
+		return nil, fmt.Errorf("unable to parse Graph response from %v: %v", source.Name(), err)
+	}
+	return list, nil
+}
@@ -0,0 +1,195 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file tests reading events from Outlook calendars via Microsoft Graph.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const graphTestFirstPage = `{
+  "value": [
+    {
+      "id": "review",
+      "subject": "Design review",
+      "start": {"dateTime": "2026-11-02T17:00:00.0000000", "timeZone": "UTC"},
+      "end": {"dateTime": "2026-11-02T18:00:00.0000000", "timeZone": "UTC"},
+      "showAs": "tentative",
+      "sensitivity": "private",
+      "responseStatus": {"response": "tentativelyAccepted"},
+      "isReminderOn": true,
+      "reminderMinutesBeforeStart": 15,
+      "location": {"displayName": "Room 4"},
+      "organizer": {"emailAddress": {"name": "Boss", "address": "Boss@Example.com"}},
+      "attendees": [
+        {"type": "required", "status": {"response": "accepted"}, "emailAddress": {"address": "boss@example.com"}},
+        {"type": "resource", "status": {"response": "accepted"}, "emailAddress": {"address": "room4@example.com"}}
+      ],
+      "onlineMeeting": {"joinUrl": "https://teams.example.com/join/1"}
+    }
+  ],
+  "@odata.nextLink": "%s/next?page=2"
+}`
+
+const graphTestSecondPage = `{
+  "value": [
+    {
+      "id": "standup",
+      "subject": "Standup",
+      "start": {"dateTime": "2026-11-02T15:00:00.0000000", "timeZone": "UTC"},
+      "end": {"dateTime": "2026-11-02T15:15:00.0000000", "timeZone": "UTC"},
+      "showAs": "busy",
+      "responseStatus": {"response": "organizer"},
+      "organizer": {"emailAddress": {"address": "me@example.com"}}
+    },
+    {
+      "id": "cancelled",
+      "subject": "Cancelled",
+      "isCancelled": true,
+      "start": {"dateTime": "2026-11-02T16:00:00.0000000", "timeZone": "UTC"},
+      "end": {"dateTime": "2026-11-02T16:30:00.0000000", "timeZone": "UTC"}
+    },
+    {
+      "id": "holiday",
+      "subject": "Holiday",
+      "isAllDay": true,
+      "showAs": "oof",
+      "start": {"dateTime": "2026-11-02T00:00:00.0000000", "timeZone": "UTC"},
+      "end": {"dateTime": "2026-11-03T00:00:00.0000000", "timeZone": "UTC"}
+    }
+  ]
+}`
+
+func TestGraphSourceEvents(t *testing.T) {
+	useLocalTime(t, "America/New_York")
+	var requests []string
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if got := req.Header.Get("Prefer"); got != `outlook.timezone="UTC"` {
+			t.Errorf("Prefer header = %q, want UTC times", got)
+		}
+		requests = append(requests, req.URL.Path)
+		w.Header().Set("Content-Type", "application/json")
+		switch req.URL.Path {
+		case "/me/calendars/work/calendarView":
+			query := req.URL.Query()
+			if query.Get("startDateTime") != "2026-11-02T00:00:00Z" || query.Get("endDateTime") != "2026-11-03T00:00:00Z" {
+				t.Errorf("window = %v to %v", query.Get("startDateTime"), query.Get("endDateTime"))
+			}
+			fmt.Fprintf(w, graphTestFirstPage, server.URL)
+		case "/next":
+			io.WriteString(w, graphTestSecondPage)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer server.Close()
+
+	source := NewGraphSource(server.URL+"/", "work", server.Client())
+	start := time.Date(2026, 11, 2, 0, 0, 0, 0, time.UTC)
+	events, err := source.Events(start, start.Add(24*time.Hour))
+	if err != nil {
+		t.Fatalf("Events: %v", err)
+	}
+	if want := []string{"/me/calendars/work/calendarView", "/next"}; !reflect.DeepEqual(requests, want) {
+		t.Errorf("requests = %v, want %v", requests, want)
+	}
+	want := []string{
+		"holiday Holiday 2026-11-02 2026-11-03",
+		"standup Standup 2026-11-02T15:00:00Z 2026-11-02T15:15:00Z",
+		"review Design review 2026-11-02T17:00:00Z 2026-11-02T18:00:00Z",
+	}
+	if got := describeEvents(events); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	holiday, standup, review := events[0], events[1], events[2]
+	if !standup.Organizer.Self || len(standup.Attendees) != 1 || standup.Attendees[0].ResponseStatus != "accepted" {
+		t.Errorf("standup should be organized by the user, got organizer %+v and attendees %+v", standup.Organizer, standup.Attendees)
+	}
+	if standup.Reminders == nil || len(standup.Reminders.Overrides) != 0 {
+		t.Errorf("standup reminders = %+v, want an empty list", standup.Reminders)
+	}
+	if review.Status != "tentative" || review.Visibility != "private" || review.Location != "Room 4" {
+		t.Errorf("review status %q, visibility %q, location %q", review.Status, review.Visibility, review.Location)
+	}
+	if review.Organizer.Email != "boss@example.com" || review.Organizer.Self {
+		t.Errorf("review organizer = %+v, want boss@example.com", review.Organizer)
+	}
+	var attendees []string
+	for _, attendee := range review.Attendees {
+		attendees = append(attendees, fmt.Sprintf("%v %v self=%v resource=%v", attendee.Email, attendee.ResponseStatus, attendee.Self, attendee.Resource))
+	}
+	wantAttendees := []string{
+		"boss@example.com accepted self=false resource=false",
+		"room4@example.com accepted self=false resource=true",
+		" tentative self=true resource=false",
+	}
+	if !reflect.DeepEqual(attendees, wantAttendees) {
+		t.Errorf("review attendees = %v, want %v", attendees, wantAttendees)
+	}
+	if reminder, ok := popupReminder(review); !ok || reminder != 15*time.Minute {
+		t.Errorf("review reminder = %v, %v; want 15m", reminder, ok)
+	}
+	if review.ConferenceData == nil || len(review.ConferenceData.EntryPoints) != 1 ||
+		review.ConferenceData.EntryPoints[0].Uri != "https://teams.example.com/join/1" {
+		t.Errorf("review conference data = %+v, want the Teams link", review.ConferenceData)
+	}
+	if holiday.EventType != "outOfOffice" {
+		t.Errorf("holiday event type = %q, want outOfOffice", holiday.EventType)
+	}
+}
+
+func TestGraphSourcePrimaryAndErrors(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path = req.URL.Path
+		http.Error(w, "denied", http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	source := NewGraphSource(server.URL, "primary", server.Client())
+	start := time.Date(2026, 11, 2, 0, 0, 0, 0, time.UTC)
+	if _, err := source.Events(start, start.Add(time.Hour)); err == nil {
+		t.Errorf("Events succeeded, want an error for a 403")
+	}
+	if path != "/me/calendarView" {
+		t.Errorf("primary calendar requested %v, want /me/calendarView", path)
+	}
+}
+
+func TestGraphResponse(t *testing.T) {
+	tests := map[string]string{
+		"organizer":           "accepted",
+		"accepted":            "accepted",
+		"tentativelyAccepted": "tentative",
+		"declined":            "declined",
+		"notResponded":        "needsAction",
+		"none":                "needsAction",
+	}
+	for response, want := range tests {
+		if got := graphResponse(response); got != want {
+			t.Errorf("graphResponse(%q) = %q, want %q", response, got, want)
+		}
+	}
+}
@@ -0,0 +1,576 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages reading events from iCalendar (ICS) files and URLs.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/teambition/rrule-go"
+	"google.golang.org/api/calendar/v3"
+)
+
+// icsProperty is a single content line of an iCalendar file, such as
+// DTSTART;TZID=Europe/London:20240102T100000
+type icsProperty struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// icsComponent is a BEGIN/END block of an iCalendar file, such as a VCALENDAR or VEVENT.
+type icsComponent struct {
+	Name       string
+	Properties []icsProperty
+	Components []*icsComponent
+}
+
+// property returns the first property with the given name, or nil if there isn't one.
+func (c *icsComponent) property(name string) *icsProperty {
+	for i := range c.Properties {
+		if c.Properties[i].Name == name {
+			return &c.Properties[i]
+		}
+	}
+	return nil
+}
+
+// propertyValue returns the unescaped text value of the first property with the given name.
+func (c *icsComponent) propertyValue(name string) string {
+	prop := c.property(name)
+	if prop == nil {
+		return ""
+	}
+	return unescapeICSText(prop.Value)
+}
+
+// allProperties returns every property with the given name.
+func (c *icsComponent) allProperties(name string) []icsProperty {
+	var props []icsProperty
+	for _, prop := range c.Properties {
+		if prop.Name == name {
+			props = append(props, prop)
+		}
+	}
+	return props
+}
+
+// unfoldICSLines splits iCalendar data into content lines, joining lines that were folded
+// by starting the continuation with a space or tab.
+func unfoldICSLines(data string) []string {
+	var lines []string
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// parseICSLine parses a single content line into its name, parameters, and value.
+func parseICSLine(line string) (icsProperty, error) {
+	prop := icsProperty{Params: make(map[string]string)}
+	nameEnd := strings.IndexAny(line, ";:")
+	if nameEnd <= 0 {
+		return prop, fmt.Errorf("invalid iCalendar line %q", line)
+	}
+	prop.Name = strings.ToUpper(line[:nameEnd])
+	rest := line[nameEnd:]
+	// Parameters may contain quoted strings, which can contain ':' and ';'.
+	for len(rest) > 0 && rest[0] == ';' {
+		rest = rest[1:]
+		equals := strings.IndexByte(rest, '=')
+		if equals < 0 {
+			return prop, fmt.Errorf("invalid iCalendar parameter in %q", line)
+		}
+		paramName := strings.ToUpper(rest[:equals])
+		rest = rest[equals+1:]
+		var paramValue string
+		if len(rest) > 0 && rest[0] == '"' {
+			closing := strings.IndexByte(rest[1:], '"')
+			if closing < 0 {
+				return prop, fmt.Errorf("unterminated quote in %q", line)
+			}
+			paramValue = rest[1 : closing+1]
+			rest = rest[closing+2:]
+		} else {
+			end := strings.IndexAny(rest, ";:")
+			if end < 0 {
+				return prop, fmt.Errorf("invalid iCalendar line %q", line)
+			}
+			paramValue = rest[:end]
+			rest = rest[end:]
+		}
+		prop.Params[paramName] = paramValue
+	}
+	if len(rest) == 0 || rest[0] != ':' {
+		return prop, fmt.Errorf("invalid iCalendar line %q", line)
+	}
+	prop.Value = rest[1:]
+	return prop, nil
+}
+
+// parseICS parses iCalendar data and returns the outermost component, normally a VCALENDAR.
+func parseICS(data []byte) (*icsComponent, error) {
+	var stack []*icsComponent
+	var root *icsComponent
+	for _, line := range unfoldICSLines(string(data)) {
+		prop, err := parseICSLine(line)
+		if err != nil {
+			return nil, err
+		}
+		switch prop.Name {
+		case "BEGIN":
+			component := &icsComponent{Name: strings.ToUpper(prop.Value)}
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, component)
+			} else if root == nil {
+				root = component
+			}
+			stack = append(stack, component)
+		case "END":
+			if len(stack) == 0 || stack[len(stack)-1].Name != strings.ToUpper(prop.Value) {
+				return nil, fmt.Errorf("unexpected END:%v", prop.Value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("property %v outside of any component", prop.Name)
+			}
+			component := stack[len(stack)-1]
+			component.Properties = append(component.Properties, prop)
+		}
+	}
+	if root == nil {
+		return nil, fmt.Errorf("no iCalendar data found")
+	}
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("missing END:%v", stack[len(stack)-1].Name)
+	}
+	return root, nil
+}
+
+func unescapeICSText(value string) string {
+	replacer := strings.NewReplacer(`\n`, "\n", `\N`, "\n", `\,`, ",", `\;`, ";", `\\`, `\`)
+	return replacer.Replace(value)
+}
+
+// icsLocation returns the time zone named by a TZID parameter, falling back to local time
+// for floating times and for zones that Go doesn't know about.
+func icsLocation(prop icsProperty) *time.Location {
+	tzid, ok := prop.Params["TZID"]
+	if !ok {
+		return time.Local
+	}
+	loc, err := time.LoadLocation(strings.TrimPrefix(tzid, "/"))
+	if err != nil {
+		debugLog("Unknown time zone %v, using local time: %v\n", tzid, err)
+		return time.Local
+	}
+	return loc
+}
+
+// parseICSTimeValue parses a single DATE or DATE-TIME value.  It returns whether the value was a date.
+func parseICSTimeValue(value string, isDate bool, loc *time.Location) (time.Time, bool, error) {
+	if isDate || len(value) == 8 {
+		t, err := time.ParseInLocation("20060102", value, time.Local)
+		return t, true, err
+	}
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse("20060102T150405Z", value)
+		return t, false, err
+	}
+	t, err := time.ParseInLocation("20060102T150405", value, loc)
+	return t, false, err
+}
+
+// parseICSTime parses a DTSTART, DTEND, or RECURRENCE-ID property.  It returns whether the value was a date.
+func parseICSTime(prop *icsProperty) (time.Time, bool, error) {
+	return parseICSTimeValue(prop.Value, prop.Params["VALUE"] == "DATE", icsLocation(*prop))
+}
+
+// parseICSTimeList parses an EXDATE or RDATE property, which may contain a comma-separated list.
+// It returns whether the values were dates.
+func parseICSTimeList(prop icsProperty) ([]time.Time, bool, error) {
+	var times []time.Time
+	isDate := prop.Params["VALUE"] == "DATE"
+	loc := icsLocation(prop)
+	for _, value := range strings.Split(prop.Value, ",") {
+		t, date, err := parseICSTimeValue(value, isDate, loc)
+		if err != nil {
+			return nil, false, err
+		}
+		isDate = date
+		times = append(times, t)
+	}
+	return times, isDate, nil
+}
+
+var icsDurationPattern = regexp.MustCompile(`^([+-])?P(?:(\d+)W)?(?:(\d+)D)?(?:T(?:(\d+)H)?(?:(\d+)M)?(?:(\d+)S)?)?$`)
+
+// parseICSDuration parses an iCalendar DURATION value such as PT30M or P1DT2H.
+func parseICSDuration(value string) (time.Duration, error) {
+	match := icsDurationPattern.FindStringSubmatch(value)
+	if match == nil {
+		return 0, fmt.Errorf("invalid duration %q", value)
+	}
+	units := []time.Duration{7 * 24 * time.Hour, 24 * time.Hour, time.Hour, time.Minute, time.Second}
+	var duration time.Duration
+	for i, unit := range units {
+		if match[i+2] != "" {
+			n, err := strconv.Atoi(match[i+2])
+			if err != nil {
+				return 0, err
+			}
+			duration += time.Duration(n) * unit
+		}
+	}
+	if match[1] == "-" {
+		duration = -duration
+	}
+	return duration, nil
+}
+
+// icsEventTimes returns the start, duration, and all-day status of a VEVENT.
+func icsEventTimes(vevent *icsComponent) (time.Time, time.Duration, bool, error) {
+	startProp := vevent.property("DTSTART")
+	if startProp == nil {
+		return time.Time{}, 0, false, fmt.Errorf("event %v has no start time", vevent.propertyValue("UID"))
+	}
+	start, allDay, err := parseICSTime(startProp)
+	if err != nil {
+		return time.Time{}, 0, false, err
+	}
+	if endProp := vevent.property("DTEND"); endProp != nil {
+		end, _, err := parseICSTime(endProp)
+		if err != nil {
+			return time.Time{}, 0, false, err
+		}
+		return start, end.Sub(start), allDay, nil
+	}
+	if durationProp := vevent.property("DURATION"); durationProp != nil {
+		duration, err := parseICSDuration(durationProp.Value)
+		return start, duration, allDay, err
+	}
+	// Per RFC 5545, an event with only a start date lasts one day, and one with only a start time lasts no time.
+	if allDay {
+		return start, 24 * time.Hour, allDay, nil
+	}
+	return start, 0, allDay, nil
+}
+
+// icsEventEnd returns when an occurrence starting at start ends.  All-day events end on a date
+// rather than after a fixed time, since days across a daylight saving change aren't 24 hours long.
+func icsEventEnd(start time.Time, duration time.Duration, allDay bool) time.Time {
+	if allDay {
+		days := int((duration + 12*time.Hour) / (24 * time.Hour))
+		return start.AddDate(0, 0, days)
+	}
+	return start.Add(duration)
+}
+
+// icsResponseStatus maps an iCalendar PARTSTAT to a Calendar API response status.
+func icsResponseStatus(partstat string) string {
+	switch strings.ToUpper(partstat) {
+	case "ACCEPTED":
+		return "accepted"
+	case "DECLINED":
+		return "declined"
+	case "TENTATIVE":
+		return "tentative"
+	}
+	return "needsAction"
+}
+
+// icsEmail extracts an email address from a CAL-ADDRESS value such as mailto:user@example.com.
+func icsEmail(value string) string {
+	if len(value) >= 7 && strings.EqualFold(value[:7], "mailto:") {
+		value = value[7:]
+	}
+	return strings.ToLower(value)
+}
+
+// icsEventToCalendarEvent converts one occurrence of a VEVENT into a Calendar API event.
+func icsEventToCalendarEvent(vevent *icsComponent, id string, start time.Time, duration time.Duration, allDay bool, selfEmails map[string]bool) *calendar.Event {
+	event := &calendar.Event{
+		Id:          id,
+		ICalUID:     vevent.propertyValue("UID"),
+		Summary:     vevent.propertyValue("SUMMARY"),
+		Description: vevent.propertyValue("DESCRIPTION"),
+		Location:    vevent.propertyValue("LOCATION"),
+		Status:      strings.ToLower(vevent.propertyValue("STATUS")),
+		EventType:   "default",
+		Start:       makeEventDateTime(start, allDay),
+		End:         makeEventDateTime(icsEventEnd(start, duration, allDay), allDay),
+	}
+	if strings.EqualFold(vevent.propertyValue("TRANSP"), "TRANSPARENT") {
+		event.Transparency = "transparent"
+	}
+	if class := vevent.propertyValue("CLASS"); class != "" {
+		event.Visibility = strings.ToLower(class)
+	}
+	for name, field := range map[string]*string{"CREATED": &event.Created, "LAST-MODIFIED": &event.Updated} {
+		if prop := vevent.property(name); prop != nil {
+			if t, _, err := parseICSTime(prop); err == nil {
+				*field = t.Format(time.RFC3339)
+			}
+		}
+	}
+	organizerEmail := ""
+	if prop := vevent.property("ORGANIZER"); prop != nil {
+		organizerEmail = icsEmail(prop.Value)
+		event.Organizer = &calendar.EventOrganizer{
+			Email:       organizerEmail,
+			DisplayName: prop.Params["CN"],
+			Self:        selfEmails[organizerEmail],
+		}
+	}
+	for _, prop := range vevent.allProperties("ATTENDEE") {
+		email := icsEmail(prop.Value)
+		cuType := strings.ToUpper(prop.Params["CUTYPE"])
+		event.Attendees = append(event.Attendees, &calendar.EventAttendee{
+			Email:          email,
+			DisplayName:    prop.Params["CN"],
+			ResponseStatus: icsResponseStatus(prop.Params["PARTSTAT"]),
+			Self:           selfEmails[email],
+			Organizer:      email == organizerEmail,
+			Resource:       cuType == "ROOM" || cuType == "RESOURCE",
+			Optional:       strings.ToUpper(prop.Params["ROLE"]) == "OPT-PARTICIPANT",
+		})
+	}
+	return event
+}
+
+// icsOccurrenceID builds an event ID for one instance of a recurring event, in the same
+// style Google Calendar uses for instances.
+func icsOccurrenceID(uid string, start time.Time) string {
+	return uid + "_" + start.UTC().Format("20060102T150405Z")
+}
+
+// icsRecurrenceSet builds the recurrence set for a VEVENT with an RRULE or RDATE.  Exceptions
+// in overridden are excluded, as those instances are provided by their own VEVENTs.
+func icsRecurrenceSet(vevent *icsComponent, start time.Time, allDay bool, overridden []time.Time) (*rrule.Set, error) {
+	set := &rrule.Set{}
+	set.DTStart(start)
+	if prop := vevent.property("RRULE"); prop != nil {
+		options, err := rrule.StrToROptionInLocation(prop.Value, start.Location())
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE %v: %v", prop.Value, err)
+		}
+		options.Dtstart = start
+		rule, err := rrule.NewRRule(*options)
+		if err != nil {
+			return nil, fmt.Errorf("invalid RRULE %v: %v", prop.Value, err)
+		}
+		set.RRule(rule)
+	} else {
+		set.RDate(start)
+	}
+	for _, prop := range vevent.allProperties("RDATE") {
+		dates, _, err := parseICSTimeList(prop)
+		if err != nil {
+			return nil, err
+		}
+		for _, date := range dates {
+			set.RDate(date)
+		}
+	}
+	for _, prop := range vevent.allProperties("EXDATE") {
+		dates, isDate, err := parseICSTimeList(prop)
+		if err != nil {
+			return nil, err
+		}
+		for _, date := range dates {
+			if isDate && !allDay {
+				// A date-only exception to a timed event excludes that day's instance.
+				date = time.Date(date.Year(), date.Month(), date.Day(), start.Hour(), start.Minute(), start.Second(), 0, start.Location())
+			}
+			set.ExDate(date)
+		}
+	}
+	for _, recurrenceID := range overridden {
+		set.ExDate(recurrenceID)
+	}
+	return set, nil
+}
+
+// expandICSEvents returns every event occurrence in the calendar that overlaps the window from
+// start to end, with recurrences expanded and exceptions applied, sorted by start time.
+func expandICSEvents(cal *icsComponent, start time.Time, end time.Time, selfEmails map[string]bool) ([]*calendar.Event, error) {
+	var masters []*icsComponent
+	overrides := make(map[string][]*icsComponent)
+	for _, component := range cal.Components {
+		if component.Name != "VEVENT" {
+			continue
+		}
+		uid := component.propertyValue("UID")
+		if component.property("RECURRENCE-ID") != nil {
+			overrides[uid] = append(overrides[uid], component)
+		} else {
+			masters = append(masters, component)
+		}
+	}
+
+	var events []*calendar.Event
+	addEvent := func(vevent *icsComponent, id string, eventStart time.Time, duration time.Duration, allDay bool) {
+		if strings.EqualFold(vevent.propertyValue("STATUS"), "CANCELLED") {
+			debugLog("Skipping cancelled ICS event %v\n", vevent.propertyValue("SUMMARY"))
+			return
+		}
+		if eventOverlaps(eventStart, icsEventEnd(eventStart, duration, allDay), start, end) {
+			events = append(events, icsEventToCalendarEvent(vevent, id, eventStart, duration, allDay, selfEmails))
+		}
+	}
+
+	for _, vevent := range masters {
+		uid := vevent.propertyValue("UID")
+		eventStart, duration, allDay, err := icsEventTimes(vevent)
+		if err != nil {
+			debugLog("Skipping ICS event %v: %v\n", uid, err)
+			continue
+		}
+		if vevent.property("RRULE") == nil && vevent.property("RDATE") == nil {
+			addEvent(vevent, uid, eventStart, duration, allDay)
+			continue
+		}
+		var overridden []time.Time
+		for _, override := range overrides[uid] {
+			recurrenceID, _, err := parseICSTime(override.property("RECURRENCE-ID"))
+			if err != nil {
+				debugLog("Skipping invalid RECURRENCE-ID for %v: %v\n", uid, err)
+				continue
+			}
+			overridden = append(overridden, recurrenceID)
+		}
+		set, err := icsRecurrenceSet(vevent, eventStart, allDay, overridden)
+		if err != nil {
+			debugLog("Skipping ICS event %v: %v\n", uid, err)
+			continue
+		}
+		lookback := duration
+		if allDay {
+			// An all-day occurrence can run an hour longer on the day the clocks go back.
+			lookback += time.Hour
+		}
+		for _, occurrence := range set.Between(start.Add(-lookback), end, true) {
+			addEvent(vevent, icsOccurrenceID(uid, occurrence), occurrence, duration, allDay)
+		}
+	}
+
+	for uid, list := range overrides {
+		for _, vevent := range list {
+			recurrenceID, _, err := parseICSTime(vevent.property("RECURRENCE-ID"))
+			if err != nil {
+				continue
+			}
+			eventStart, duration, allDay, err := icsEventTimes(vevent)
+			if err != nil {
+				debugLog("Skipping ICS event %v: %v\n", uid, err)
+				continue
+			}
+			addEvent(vevent, icsOccurrenceID(uid, recurrenceID), eventStart, duration, allDay)
+		}
+	}
+
+	sortEventsByStart(events)
+	return events, nil
+}
+
+// ICSSource reads events from an iCalendar file on disk or an ICS feed URL.
+type ICSSource struct {
+	location   string
+	selfEmails map[string]bool
+	client     *http.Client
+}
+
+// NewICSSource creates a source for the given file path or http(s)/webcal URL.  selfEmails
+// lists the attendee addresses that identify the user, so that responses can be checked.
+func NewICSSource(location string, selfEmails []string) *ICSSource {
+	source := &ICSSource{
+		location:   location,
+		selfEmails: make(map[string]bool),
+		client:     &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, email := range selfEmails {
+		source.selfEmails[strings.ToLower(email)] = true
+	}
+	return source
+}
+
+// Name returns the name of the feed; see icsFeedName.
+func (source *ICSSource) Name() string {
+	return icsFeedName(source.location)
+}
+
+// icsFeedName returns the file path, or for URLs the scheme and host with a short hash of the
+// whole URL.  Feed URLs usually contain a secret token, so they can't be shown, but feeds on the
+// same host still need different names for rules and calendar settings to tell them apart.
+func icsFeedName(location string) string {
+	name := redactURL(location)
+	if name == location {
+		return location
+	}
+	sum := sha256.Sum256([]byte(location))
+	return name + "#" + hex.EncodeToString(sum[:4])
+}
+
+func (source *ICSSource) read() ([]byte, error) {
+	location := source.location
+	if strings.HasPrefix(location, "webcal://") {
+		location = "https://" + strings.TrimPrefix(location, "webcal://")
+	}
+	if !strings.HasPrefix(location, "http://") && !strings.HasPrefix(location, "https://") {
+		return os.ReadFile(location)
+	}
+	resp, err := source.client.Get(location)
+	if err != nil {
+		return nil, fmt.Errorf("unable to fetch ICS feed %v: %v", source.Name(), redactURLError(err))
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unable to fetch ICS feed %v: %v", source.Name(), resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+func (source *ICSSource) Events(start time.Time, end time.Time) ([]*calendar.Event, error) {
+	data, err := source.read()
+	if err != nil {
+		return nil, err
+	}
+	cal, err := parseICS(data)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse ICS data from %v: %v", source.Name(), err)
+	}
+	events, err := expandICSEvents(cal, start, end, source.selfEmails)
+	debugLog("Read %d events from %v\n", len(events), source.Name())
+	return events, err
+}
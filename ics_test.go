@@ -0,0 +1,378 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file tests reading iCalendar data.
+
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// useLocalTime sets the local time zone for the rest of the test, since all-day and floating
+// times are read in local time.
+func useLocalTime(t *testing.T, name string) *time.Location {
+	t.Helper()
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Skipf("time zone %v not available: %v", name, err)
+	}
+	saved := time.Local
+	time.Local = loc
+	t.Cleanup(func() { time.Local = saved })
+	return loc
+}
+
+// makeICS wraps VEVENT content lines in a calendar.
+func makeICS(lines ...string) []byte {
+	all := append([]string{"BEGIN:VCALENDAR", "VERSION:2.0"}, lines...)
+	all = append(all, "END:VCALENDAR")
+	return []byte(strings.Join(all, "\r\n") + "\r\n")
+}
+
+// describeEvents summarizes events as "id summary start end" for comparing.
+func describeEvents(events []*calendar.Event) []string {
+	var described []string
+	for _, event := range events {
+		start, end := event.Start.DateTime, event.End.DateTime
+		if start == "" {
+			start, end = event.Start.Date, event.End.Date
+		}
+		described = append(described, fmt.Sprintf("%v %v %v %v", event.Id, event.Summary, start, end))
+	}
+	return described
+}
+
+func TestParseICS(t *testing.T) {
+	data := makeICS(
+		"BEGIN:VEVENT",
+		"UID:folded",
+		"SUMMARY:A long title that was",
+		"  folded onto a second line",
+		`DESCRIPTION:Line one\nLine two\, with a comma`,
+		`ATTENDEE;CN="Smith; Jo";PARTSTAT=ACCEPTED:mailto:Jo@Example.com`,
+		"DTSTART;TZID=Europe/London:20240102T100000",
+		"END:VEVENT",
+	)
+	cal, err := parseICS(data)
+	if err != nil {
+		t.Fatalf("parseICS: %v", err)
+	}
+	if cal.Name != "VCALENDAR" || len(cal.Components) != 1 {
+		t.Fatalf("got %v with %d components, want VCALENDAR with 1", cal.Name, len(cal.Components))
+	}
+	vevent := cal.Components[0]
+	if got, want := vevent.propertyValue("SUMMARY"), "A long title that was folded onto a second line"; got != want {
+		t.Errorf("SUMMARY = %q, want %q", got, want)
+	}
+	if got, want := vevent.propertyValue("DESCRIPTION"), "Line one\nLine two, with a comma"; got != want {
+		t.Errorf("DESCRIPTION = %q, want %q", got, want)
+	}
+	attendee := vevent.property("ATTENDEE")
+	if attendee == nil {
+		t.Fatalf("no ATTENDEE")
+	}
+	if got, want := attendee.Params, map[string]string{"CN": "Smith; Jo", "PARTSTAT": "ACCEPTED"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ATTENDEE params = %v, want %v", got, want)
+	}
+	if got, want := attendee.Value, "mailto:Jo@Example.com"; got != want {
+		t.Errorf("ATTENDEE value = %q, want %q", got, want)
+	}
+	if got, want := vevent.property("DTSTART").Params["TZID"], "Europe/London"; got != want {
+		t.Errorf("DTSTART TZID = %q, want %q", got, want)
+	}
+}
+
+func TestParseICSErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+	}{
+		{"empty", ""},
+		{"no colon", "BEGIN:VCALENDAR\r\nSUMMARY\r\nEND:VCALENDAR\r\n"},
+		{"unterminated quote", "BEGIN:VCALENDAR\r\nX;CN=\"open:value\r\nEND:VCALENDAR\r\n"},
+		{"mismatched end", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nEND:VCALENDAR\r\n"},
+		{"missing end", "BEGIN:VCALENDAR\r\nBEGIN:VEVENT\r\nEND:VEVENT\r\n"},
+		{"outside component", "SUMMARY:stray\r\n"},
+	}
+	for _, test := range tests {
+		if _, err := parseICS([]byte(test.data)); err == nil {
+			t.Errorf("%v: parseICS succeeded, want an error", test.name)
+		}
+	}
+}
+
+func TestParseICSDuration(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"PT30M", 30 * time.Minute, false},
+		{"PT1H30M", 90 * time.Minute, false},
+		{"P1DT2H", 26 * time.Hour, false},
+		{"P2W", 14 * 24 * time.Hour, false},
+		{"PT45S", 45 * time.Second, false},
+		{"+PT15M", 15 * time.Minute, false},
+		{"-PT15M", -15 * time.Minute, false},
+		{"P", 0, false},
+		{"30M", 0, true},
+		{"PT", 0, false},
+		{"P1H", 0, true},
+	}
+	for _, test := range tests {
+		got, err := parseICSDuration(test.value)
+		if (err != nil) != test.wantErr {
+			t.Errorf("parseICSDuration(%q) error = %v, want error %v", test.value, err, test.wantErr)
+			continue
+		}
+		if got != test.want {
+			t.Errorf("parseICSDuration(%q) = %v, want %v", test.value, got, test.want)
+		}
+	}
+}
+
+func TestICSResponseStatus(t *testing.T) {
+	tests := map[string]string{
+		"ACCEPTED":     "accepted",
+		"accepted":     "accepted",
+		"DECLINED":     "declined",
+		"TENTATIVE":    "tentative",
+		"NEEDS-ACTION": "needsAction",
+		"DELEGATED":    "needsAction",
+		"":             "needsAction",
+	}
+	for partstat, want := range tests {
+		if got := icsResponseStatus(partstat); got != want {
+			t.Errorf("icsResponseStatus(%q) = %q, want %q", partstat, got, want)
+		}
+	}
+}
+
+func TestICSAttendees(t *testing.T) {
+	cal, err := parseICS(makeICS(
+		"BEGIN:VEVENT",
+		"UID:meeting",
+		"SUMMARY:Planning",
+		"DTSTART:20261102T150000Z",
+		"DTEND:20261102T160000Z",
+		"ORGANIZER;CN=Boss:mailto:boss@example.com",
+		"ATTENDEE;PARTSTAT=ACCEPTED:mailto:boss@example.com",
+		"ATTENDEE;PARTSTAT=TENTATIVE;ROLE=OPT-PARTICIPANT:mailto:Me@Example.com",
+		"ATTENDEE;CUTYPE=ROOM;PARTSTAT=ACCEPTED:mailto:room@example.com",
+		"END:VEVENT",
+	))
+	if err != nil {
+		t.Fatalf("parseICS: %v", err)
+	}
+	start := time.Date(2026, 11, 2, 0, 0, 0, 0, time.UTC)
+	events, err := expandICSEvents(cal, start, start.Add(24*time.Hour), map[string]bool{"me@example.com": true})
+	if err != nil || len(events) != 1 {
+		t.Fatalf("expandICSEvents = %d events, %v; want 1 event", len(events), err)
+	}
+	event := events[0]
+	if event.Organizer == nil || event.Organizer.Email != "boss@example.com" || event.Organizer.Self {
+		t.Errorf("organizer = %+v, want boss@example.com, not self", event.Organizer)
+	}
+	var got []string
+	for _, attendee := range event.Attendees {
+		got = append(got, fmt.Sprintf("%v %v self=%v organizer=%v optional=%v resource=%v",
+			attendee.Email, attendee.ResponseStatus, attendee.Self, attendee.Organizer, attendee.Optional, attendee.Resource))
+	}
+	want := []string{
+		"boss@example.com accepted self=false organizer=true optional=false resource=false",
+		"me@example.com tentative self=true organizer=false optional=true resource=false",
+		"room@example.com accepted self=false organizer=false optional=false resource=true",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("attendees =\n%v\nwant\n%v", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestExpandICSEvents(t *testing.T) {
+	loc := useLocalTime(t, "America/New_York")
+	tests := []struct {
+		name  string
+		lines []string
+		start time.Time
+		end   time.Time
+		want  []string
+	}{
+		{
+			name: "single event",
+			lines: []string{
+				"BEGIN:VEVENT", "UID:one", "SUMMARY:Standup",
+				"DTSTART:20261102T150000Z", "DTEND:20261102T153000Z",
+				"END:VEVENT",
+			},
+			start: time.Date(2026, 11, 2, 0, 0, 0, 0, loc),
+			end:   time.Date(2026, 11, 3, 0, 0, 0, 0, loc),
+			want:  []string{"one Standup 2026-11-02T15:00:00Z 2026-11-02T15:30:00Z"},
+		},
+		{
+			name: "duration instead of end",
+			lines: []string{
+				"BEGIN:VEVENT", "UID:dur", "SUMMARY:Review",
+				"DTSTART:20261102T150000Z", "DURATION:PT45M",
+				"END:VEVENT",
+			},
+			start: time.Date(2026, 11, 2, 0, 0, 0, 0, loc),
+			end:   time.Date(2026, 11, 3, 0, 0, 0, 0, loc),
+			want:  []string{"dur Review 2026-11-02T15:00:00Z 2026-11-02T15:45:00Z"},
+		},
+		{
+			name: "outside the window",
+			lines: []string{
+				"BEGIN:VEVENT", "UID:later", "SUMMARY:Later",
+				"DTSTART:20261110T150000Z", "DTEND:20261110T153000Z",
+				"END:VEVENT",
+			},
+			start: time.Date(2026, 11, 2, 0, 0, 0, 0, loc),
+			end:   time.Date(2026, 11, 3, 0, 0, 0, 0, loc),
+			want:  nil,
+		},
+		{
+			name: "weekly timed event keeps its local time across the clocks going back",
+			lines: []string{
+				"BEGIN:VEVENT", "UID:weekly", "SUMMARY:Sync",
+				"DTSTART;TZID=America/New_York:20261026T090000",
+				"DTEND;TZID=America/New_York:20261026T093000",
+				"RRULE:FREQ=WEEKLY;COUNT=3",
+				"END:VEVENT",
+			},
+			start: time.Date(2026, 10, 25, 0, 0, 0, 0, loc),
+			end:   time.Date(2026, 11, 20, 0, 0, 0, 0, loc),
+			want: []string{
+				"weekly_20261026T130000Z Sync 2026-10-26T09:00:00-04:00 2026-10-26T09:30:00-04:00",
+				"weekly_20261102T140000Z Sync 2026-11-02T09:00:00-05:00 2026-11-02T09:30:00-05:00",
+				"weekly_20261109T140000Z Sync 2026-11-09T09:00:00-05:00 2026-11-09T09:30:00-05:00",
+			},
+		},
+		{
+			name: "weekly all-day event lasts a whole day when the clocks go back",
+			lines: []string{
+				"BEGIN:VEVENT", "UID:allday", "SUMMARY:Holiday",
+				"DTSTART;VALUE=DATE:20261025", "DTEND;VALUE=DATE:20261026",
+				"RRULE:FREQ=WEEKLY",
+				"END:VEVENT",
+			},
+			start: time.Date(2026, 10, 30, 0, 0, 0, 0, loc),
+			end:   time.Date(2026, 11, 10, 0, 0, 0, 0, loc),
+			want: []string{
+				"allday_20261101T040000Z Holiday 2026-11-01 2026-11-02",
+				"allday_20261108T050000Z Holiday 2026-11-08 2026-11-09",
+			},
+		},
+		{
+			name: "all-day event in progress when the window starts",
+			lines: []string{
+				"BEGIN:VEVENT", "UID:allday", "SUMMARY:Holiday",
+				"DTSTART;VALUE=DATE:20261025", "DTEND;VALUE=DATE:20261026",
+				"RRULE:FREQ=WEEKLY",
+				"END:VEVENT",
+			},
+			start: time.Date(2026, 11, 1, 23, 30, 0, 0, loc),
+			end:   time.Date(2026, 11, 2, 0, 0, 0, 0, loc),
+			want:  []string{"allday_20261101T040000Z Holiday 2026-11-01 2026-11-02"},
+		},
+		{
+			name: "exclusions",
+			lines: []string{
+				"BEGIN:VEVENT", "UID:daily", "SUMMARY:Check-in",
+				"DTSTART:20261102T150000Z", "DTEND:20261102T151500Z",
+				"RRULE:FREQ=DAILY;COUNT=4",
+				"EXDATE:20261103T150000Z",
+				"EXDATE;VALUE=DATE:20261104",
+				"END:VEVENT",
+			},
+			start: time.Date(2026, 11, 2, 0, 0, 0, 0, loc),
+			end:   time.Date(2026, 11, 10, 0, 0, 0, 0, loc),
+			want: []string{
+				"daily_20261102T150000Z Check-in 2026-11-02T15:00:00Z 2026-11-02T15:15:00Z",
+				"daily_20261105T150000Z Check-in 2026-11-05T15:00:00Z 2026-11-05T15:15:00Z",
+			},
+		},
+		{
+			name: "overridden and cancelled instances",
+			lines: []string{
+				"BEGIN:VEVENT", "UID:daily", "SUMMARY:Check-in",
+				"DTSTART:20261102T150000Z", "DTEND:20261102T151500Z",
+				"RRULE:FREQ=DAILY;COUNT=3",
+				"END:VEVENT",
+				"BEGIN:VEVENT", "UID:daily", "SUMMARY:Moved check-in",
+				"RECURRENCE-ID:20261103T150000Z",
+				"DTSTART:20261103T180000Z", "DTEND:20261103T181500Z",
+				"END:VEVENT",
+				"BEGIN:VEVENT", "UID:daily", "SUMMARY:Check-in", "STATUS:CANCELLED",
+				"RECURRENCE-ID:20261104T150000Z",
+				"DTSTART:20261104T150000Z", "DTEND:20261104T151500Z",
+				"END:VEVENT",
+			},
+			start: time.Date(2026, 11, 2, 0, 0, 0, 0, loc),
+			end:   time.Date(2026, 11, 10, 0, 0, 0, 0, loc),
+			want: []string{
+				"daily_20261102T150000Z Check-in 2026-11-02T15:00:00Z 2026-11-02T15:15:00Z",
+				"daily_20261103T150000Z Moved check-in 2026-11-03T18:00:00Z 2026-11-03T18:15:00Z",
+			},
+		},
+		{
+			name: "cancelled event",
+			lines: []string{
+				"BEGIN:VEVENT", "UID:gone", "SUMMARY:Gone", "STATUS:CANCELLED",
+				"DTSTART:20261102T150000Z", "DTEND:20261102T153000Z",
+				"END:VEVENT",
+			},
+			start: time.Date(2026, 11, 2, 0, 0, 0, 0, loc),
+			end:   time.Date(2026, 11, 3, 0, 0, 0, 0, loc),
+			want:  nil,
+		},
+	}
+	for _, test := range tests {
+		cal, err := parseICS(makeICS(test.lines...))
+		if err != nil {
+			t.Errorf("%v: parseICS: %v", test.name, err)
+			continue
+		}
+		events, err := expandICSEvents(cal, test.start, test.end, nil)
+		if err != nil {
+			t.Errorf("%v: expandICSEvents: %v", test.name, err)
+			continue
+		}
+		if got := describeEvents(events); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%v: got\n%v\nwant\n%v", test.name, strings.Join(got, "\n"), strings.Join(test.want, "\n"))
+		}
+	}
+}
+
+func TestICSFeedName(t *testing.T) {
+	first := icsFeedName("https://calendar.example.com/feeds/secret-one/basic.ics")
+	second := icsFeedName("https://calendar.example.com/feeds/secret-two/basic.ics")
+	if first == second {
+		t.Errorf("feeds on the same host both named %v", first)
+	}
+	for _, name := range []string{first, second} {
+		if strings.Contains(name, "secret") || !strings.HasPrefix(name, "https://calendar.example.com/...#") {
+			t.Errorf("feed name %v should only show the host and a hash", name)
+		}
+	}
+	if got, want := icsFeedName("/home/me/holidays.ics"), "/home/me/holidays.ics"; got != want {
+		t.Errorf("icsFeedName(%q) = %q, want the path unchanged", want, got)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages the reminder for invitations that haven't been answered.
+
+package main
+
+import (
+	"sort"
+	"time"
+
+	blink1 "github.com/kazrakcom/go-blink1"
+	"google.golang.org/api/calendar/v3"
+)
+
+// How long each half of the slow reminder pulse takes.
+const invitationPulse = 3 * time.Second
+
+// The default reminder color, a dim cyan.
+var defaultInvitationColor = blink1.State{Green: 32, Blue: 48}
+
+// makeInvitationState returns the reminder state for a color: a slow pulse on LED 2.
+func makeInvitationState(color blink1.State) CalendarState {
+	return CalendarState{Name: "Pending Invitations", primary: blink1.OffState, secondary: color, secondaryFlash: invitationPulse}
+}
+
+// InvitationTracker counts the invitations in the next few days that haven't been answered.
+// It has its own window and fetches much less often than the countdown, since a reminder
+// that is a little out of date doesn't matter.  Only each account's primary calendar is
+// checked: on other calendars, the attendee marked as self is whoever the calendar belongs to,
+// so their invitations aren't the user's.
+type InvitationTracker struct {
+	lister    CalendarLister
+	calendars []string
+	window    time.Duration
+	interval  time.Duration
+	retry     time.Duration
+	count     int
+	nextFetch time.Time
+}
+
+// NewInvitationTracker creates a tracker for the user's Google accounts.  It returns nil if
+// the reminder is turned off or there are no Google calendars.
+func NewInvitationTracker(accounts googleAccounts, userPrefs *UserPrefs) *InvitationTracker {
+	if len(accounts) == 0 || userPrefs.InvitationDays <= 0 {
+		return nil
+	}
+	var calendars []string
+	for name := range accounts {
+		calendars = append(calendars, accountCalendarID(name, "primary"))
+	}
+	sort.Strings(calendars)
+	return &InvitationTracker{
+		calendars: calendars,
+		// Always list directly, so the countdown's sync window isn't stretched to cover days.
+		lister: accounts.listers(func(srv *calendar.Service) CalendarLister {
+			return googleLister{srv: srv, maxEvents: userPrefs.MaxEventsPerCalendar}
+		}),
+		window:   time.Duration(userPrefs.InvitationDays) * 24 * time.Hour,
+		interval: time.Duration(userPrefs.InvitationInterval) * time.Second,
+		retry:    time.Duration(userPrefs.PollInterval) * time.Second,
+	}
+}
+
+// isPendingInvitation returns true if the user hasn't responded to the event at all.
+func isPendingInvitation(event *calendar.Event) bool {
+	if event.Status == "cancelled" {
+		return false
+	}
+	for _, attendee := range event.Attendees {
+		if attendee.Self {
+			return attendee.ResponseStatus == "needsAction"
+		}
+	}
+	return false
+}
+
+// Poll fetches the invitations if it is time to, and returns true if it did.  On errors the
+// last count is kept, and the fetch is retried at the normal poll interval.
+func (tracker *InvitationTracker) Poll(now time.Time, userPrefs *UserPrefs) bool {
+	if tracker == nil || tracker.nextFetch.After(now) {
+		return false
+	}
+	count, err := tracker.fetch(now, userPrefs)
+	if err != nil {
+		errorLog("Error receiving invitations from server:\n%v\n", err)
+		tracker.nextFetch = now.Add(tracker.retry)
+		return true
+	}
+	if count != tracker.count {
+		debugLog("Pending invitations changed from %d to %d\n", tracker.count, count)
+	}
+	tracker.count = count
+	tracker.nextFetch = now.Add(tracker.interval)
+	return true
+}
+
+func (tracker *InvitationTracker) fetch(now time.Time, userPrefs *UserPrefs) (int, error) {
+	end := now.Add(tracker.window)
+	seen := make(map[string]bool)
+	count := 0
+	var lastErr error
+	failed := 0
+	for _, calendarID := range tracker.calendars {
+		events, err := tracker.lister.List(calendarID, now, end)
+		if err != nil {
+			// Count what the other accounts have rather than losing them all.
+			errorLog("Unable to read invitations from calendar %v: %v\n", calendarID, err)
+			lastErr = err
+			failed++
+			continue
+		}
+		for _, event := range events {
+			if seen[event.Id] || !isPendingInvitation(event) {
+				continue
+			}
+			seen[event.Id] = true
+			if rule, include := applyRules(event, calendarID, userPrefs); rule != "" && !include {
+				debugLog("Not counting invitation '%v' due to %v\n", event.Summary, rule)
+				continue
+			}
+			verboseLog("Pending invitation: %v\n", event.Summary)
+			count++
+		}
+	}
+	if failed == len(tracker.calendars) {
+		return 0, lastErr
+	}
+	return count, nil
+}
+
+// State returns the reminder to show when nothing else is, or the given state unchanged.
+func (tracker *InvitationTracker) State(in CalendarState, userPrefs *UserPrefs) CalendarState {
+	if tracker == nil || tracker.count == 0 || in != Black {
+		return in
+	}
+	return userPrefs.InvitationState
+}
@@ -0,0 +1,209 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages the ladders of states shown as an event approaches, and the color schemes for them.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	blink1 "github.com/kazrakcom/go-blink1"
+)
+
+// Ladders and schemes are given in the config file as named tables:
+//   [ladder.interview]
+//   green = 90
+//   yellow = 45
+//   red = 20
+//   redFlash = 10
+//   fastRedFlash = 5
+//   startFlash = 0
+//   inMeeting = -1
+//
+//   [scheme.manager]
+//   green = "#00ffff"
+//   yellow = "#8000ff"
+//
+// Each ladder entry is a stage and the number of minutes before the event starts at which the
+// stage begins; stages that are left out are never shown.  Each scheme entry replaces the color
+// of a stage with a "#rrggbb" color, keeping its flash pattern.
+
+// The stages of a ladder, and the state each shows by default.
+var stageStates = map[string]CalendarState{
+	"green":        Green,
+	"yellow":       Yellow,
+	"red":          Red,
+	"redFlash":     RedFlash,
+	"fastRedFlash": FastRedFlash,
+	"startFlash":   BlueFlash,
+	"inMeeting":    Blue,
+}
+
+// ladderStep shows a stage when the event starts in less than before.
+type ladderStep struct {
+	before time.Duration
+	stage  string
+}
+
+// Ladder is the sequence of stages shown as an event approaches and starts.
+type Ladder struct {
+	Name string
+	// Sorted with the latest stage first.
+	steps []ladderStep
+}
+
+var defaultLadder = &Ladder{Name: "default", steps: []ladderStep{
+	{before: -1 * time.Minute, stage: "inMeeting"},
+	{before: 0, stage: "startFlash"},
+	{before: 2 * time.Minute, stage: "fastRedFlash"},
+	{before: 5 * time.Minute, stage: "redFlash"},
+	{before: 10 * time.Minute, stage: "red"},
+	{before: 30 * time.Minute, stage: "yellow"},
+	{before: 60 * time.Minute, stage: "green"},
+}}
+
+func makeLadder(name string, layout map[string]int64) (*Ladder, error) {
+	ladder := &Ladder{Name: name}
+	for stage, minutes := range layout {
+		if _, ok := stageStates[stage]; !ok {
+			return nil, fmt.Errorf("ladder %v: unknown stage %v", name, stage)
+		}
+		ladder.steps = append(ladder.steps, ladderStep{before: time.Duration(minutes) * time.Minute, stage: stage})
+	}
+	sort.Slice(ladder.steps, func(i, j int) bool {
+		return ladder.steps[i].before < ladder.steps[j].before
+	})
+	return ladder, nil
+}
+
+// Stage returns the stage of the ladder for an event starting after delta, or "" if the event
+// is too far away for any stage.
+func (ladder *Ladder) Stage(delta time.Duration) string {
+	for _, step := range ladder.steps {
+		if delta < step.before {
+			return step.stage
+		}
+	}
+	return ""
+}
+
+// State returns the state to show for an event starting after delta, in the given scheme.  A nil
+// scheme uses the default colors.
+func (ladder *Ladder) State(delta time.Duration, scheme *Scheme) CalendarState {
+	stage := ladder.Stage(delta)
+	if stage == "" {
+		return Black
+	}
+	return scheme.State(stage)
+}
+
+// StateFrom is like State, but the warnings start when the event is start away instead of where
+// the ladder starts them.  Nothing is shown before then, and from then on the ladder's stage is
+// shown, or its earliest warning stage until the ladder reaches one.
+func (ladder *Ladder) StateFrom(delta time.Duration, start time.Duration, scheme *Scheme) CalendarState {
+	if delta >= start && delta > 0 {
+		return Black
+	}
+	stage := ladder.Stage(delta)
+	if stage == "" {
+		if len(ladder.steps) == 0 || ladder.steps[len(ladder.steps)-1].before <= 0 {
+			return Black
+		}
+		stage = ladder.steps[len(ladder.steps)-1].stage
+	}
+	return scheme.State(stage)
+}
+
+// Scheme is a set of replacement colors for ladder stages.
+type Scheme struct {
+	Name   string
+	states map[string]CalendarState
+}
+
+// parseColor parses a color in "#rrggbb" form.
+func parseColor(color string) (blink1.State, error) {
+	hex := strings.TrimPrefix(color, "#")
+	if len(hex) != 6 {
+		return blink1.OffState, fmt.Errorf("bad color %v, should be #rrggbb", color)
+	}
+	value, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return blink1.OffState, fmt.Errorf("bad color %v: %v", color, err)
+	}
+	return blink1.State{Red: uint8(value >> 16), Green: uint8(value >> 8), Blue: uint8(value)}, nil
+}
+
+// recolor returns the state with its main color replaced.  Steady states show the same color
+// on both LEDs, so both are replaced; flashing states keep their second color.
+func recolor(in CalendarState, name string, color blink1.State) CalendarState {
+	out := in
+	out.Name = name + " " + in.Name
+	out.primary = color
+	if in.secondary == in.primary {
+		out.secondary = color
+	}
+	return out
+}
+
+func makeScheme(name string, layout map[string]string) (*Scheme, error) {
+	scheme := &Scheme{Name: name, states: make(map[string]CalendarState)}
+	for stage, color := range layout {
+		base, ok := stageStates[stage]
+		if !ok {
+			return nil, fmt.Errorf("scheme %v: unknown stage %v", name, stage)
+		}
+		state, err := parseColor(color)
+		if err != nil {
+			return nil, fmt.Errorf("scheme %v: %v", name, err)
+		}
+		scheme.states[stage] = recolor(base, name, state)
+	}
+	return scheme, nil
+}
+
+// State returns the state for a stage in this scheme.
+func (scheme *Scheme) State(stage string) CalendarState {
+	if scheme != nil {
+		if state, ok := scheme.states[stage]; ok {
+			return state
+		}
+	}
+	return stageStates[stage]
+}
+
+// makeLadders converts the ladders and schemes read from the config file.
+func makeLadders(ladderLayouts map[string]map[string]int64, schemeLayouts map[string]map[string]string) (map[string]*Ladder, map[string]*Scheme, error) {
+	ladders := make(map[string]*Ladder)
+	for name, layout := range ladderLayouts {
+		ladder, err := makeLadder(name, layout)
+		if err != nil {
+			return nil, nil, err
+		}
+		ladders[name] = ladder
+	}
+	schemes := make(map[string]*Scheme)
+	for name, layout := range schemeLayouts {
+		scheme, err := makeScheme(name, layout)
+		if err != nil {
+			return nil, nil, err
+		}
+		schemes[name] = scheme
+	}
+	return ladders, schemes, nil
+}
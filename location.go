@@ -0,0 +1,130 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages tracking the working location set on each calendar.
+
+package main
+
+import (
+	"sort"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// dayLocation is the working location set for a whole day.
+type dayLocation struct {
+	site    WorkSite
+	created time.Time
+}
+
+// locationOverride is a working location set for part of a day.
+type locationOverride struct {
+	site  WorkSite
+	start time.Time
+	end   time.Time
+}
+
+// calendarLocations holds the working locations set on one calendar.
+//
+// The calendar can return three or more working location events for a day:
+// 1. The recurring one for the given day of the week
+// 2. The override for that particular day
+// 3. Any time overrides that are currently set for specific hours of the day.
+// The latest-created all-day event is the location for the day, and the time overrides replace
+// it for the events that start within them.
+type calendarLocations struct {
+	days      map[string]dayLocation
+	overrides []locationOverride
+}
+
+func newCalendarLocations() *calendarLocations {
+	return &calendarLocations{days: make(map[string]dayLocation)}
+}
+
+// makeEventWorkSite returns the location a working location event sets.
+func makeEventWorkSite(event *calendar.Event) WorkSite {
+	locationProperties := event.WorkingLocationProperties
+	locationType := makeWorkSiteType(locationProperties.Type)
+	locationString := ""
+	switch locationType {
+	case WorkSiteOffice:
+		locationString = locationProperties.OfficeLocation.Label
+	case WorkSiteCustom:
+		locationString = locationProperties.CustomLocation.Label
+	}
+	return WorkSite{SiteType: locationType, Name: locationString}
+}
+
+// add records a working location event.
+func (locations *calendarLocations) add(event *calendar.Event, calendarID string) {
+	site := makeEventWorkSite(event)
+	if event.Start.DateTime != "" {
+		debugLog("Location override detected: calendar %v, location %v\n", calendarID, site)
+		locations.overrides = append(locations.overrides, locationOverride{site: site, start: eventStartTime(event), end: eventEndTime(event)})
+		return
+	}
+	created, err := time.Parse(time.RFC3339, event.Created)
+	if err != nil {
+		debugLog("Skipping location event %v because of bad creation time: %v\n", event.Summary, err)
+		return
+	}
+	// All-day events can cover several days, so record the location for each of them.
+	for day := eventStartTime(event); day.Before(eventEndTime(event)); day = day.AddDate(0, 0, 1) {
+		key := day.Format("2006-01-02")
+		if current, ok := locations.days[key]; ok && created.Before(current.created) {
+			debugLog("Skipping location event %v because it's before the current one\n", event.Summary)
+			continue
+		}
+		debugLog("Location detected: calendar %v, day %v, location %v\n", calendarID, key, site)
+		locations.days[key] = dayLocation{site: site, created: created}
+	}
+}
+
+// at returns the working location at the given time, and false if there is none.
+func (locations *calendarLocations) at(t time.Time) (WorkSite, bool) {
+	for _, override := range locations.overrides {
+		if !override.start.After(t) && override.end.After(t) {
+			return override.site, true
+		}
+	}
+	day, ok := locations.days[t.In(time.Local).Format("2006-01-02")]
+	return day.site, ok
+}
+
+// eventLocations returns the working locations that apply to an event: the location set on its
+// own calendar when it starts.  Calendars and sources that don't have working locations use the
+// locations of every calendar that does.
+func eventLocations(event *calendar.Event, calendarID string, locations map[string]*calendarLocations) []WorkSite {
+	start := eventStartTime(event)
+	if own, ok := locations[calendarID]; ok {
+		if site, ok := own.at(start); ok {
+			return []WorkSite{site}
+		}
+		return nil
+	}
+	// Go through the calendars in a fixed order, so the first location is always the same.
+	calendarIDs := make([]string, 0, len(locations))
+	for id := range locations {
+		calendarIDs = append(calendarIDs, id)
+	}
+	sort.Strings(calendarIDs)
+	var sites []WorkSite
+	for _, id := range calendarIDs {
+		if site, ok := locations[id].at(start); ok {
+			sites = append(sites, site)
+		}
+	}
+	return sites
+}
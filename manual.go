@@ -0,0 +1,268 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages events from a local file of manually entered events.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/fsnotify/fsnotify"
+	"google.golang.org/api/calendar/v3"
+)
+
+// Manual events file:
+// TOML (or JSON, if the file name ends in .json) file with the following structure:
+//   [[event]]
+//   title = "School pickup"
+//   start = "15:00"
+//   end = "15:30"
+//   days = ["weekdays"]
+//
+//   [[event]]
+//   title = "Dentist"
+//   date = "2026-11-03"
+//   start = "10:00"
+//   duration = "1h"
+//
+// Notes on items:
+// Date makes a one-off event on that date.  Without a date, the event repeats on the given days.
+// Days may be weekday names, "weekdays", "weekends", or "daily".  If unset, the event repeats every day.
+// Either end (hh:mm, 24 hr format) or duration (a Go duration such as "30m") may be given.  If neither
+//   is, the event lasts 30 minutes.
+
+// Struct used for decoding the manual events file.
+type manualEventsLayout struct {
+	Event []struct {
+		Title    string
+		Date     string
+		Start    string
+		End      string
+		Duration string
+		Days     []string
+	}
+}
+
+// manualEvent is a single parsed entry from the manual events file.
+type manualEvent struct {
+	title    string
+	date     time.Time
+	start    time.Duration
+	duration time.Duration
+	days     [7]bool
+}
+
+// parseClockTime parses an hh:mm time into an offset from midnight.
+func parseClockTime(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// parseManualEvents reads and parses the manual events file.
+func parseManualEvents(path string) ([]manualEvent, error) {
+	layout := manualEventsLayout{}
+	if strings.HasSuffix(path, ".json") {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal(data, &layout); err != nil {
+			return nil, err
+		}
+	} else if _, err := toml.DecodeFile(path, &layout); err != nil {
+		return nil, err
+	}
+
+	weekdays := make(map[string]int)
+	for i := 0; i < 7; i++ {
+		weekdays[strings.ToLower(time.Weekday(i).String())] = i
+	}
+	var events []manualEvent
+	for _, item := range layout.Event {
+		event := manualEvent{title: item.Title}
+		var err error
+		if event.start, err = parseClockTime(item.Start); err != nil {
+			return nil, fmt.Errorf("invalid start time %v for %v: %v", item.Start, item.Title, err)
+		}
+		switch {
+		case item.End != "":
+			end, err := parseClockTime(item.End)
+			if err != nil {
+				return nil, fmt.Errorf("invalid end time %v for %v: %v", item.End, item.Title, err)
+			}
+			event.duration = end - event.start
+			if event.duration <= 0 {
+				// An end time earlier than the start means the event runs past midnight.
+				event.duration += 24 * time.Hour
+			}
+		case item.Duration != "":
+			if event.duration, err = time.ParseDuration(item.Duration); err != nil {
+				return nil, fmt.Errorf("invalid duration %v for %v: %v", item.Duration, item.Title, err)
+			}
+		default:
+			event.duration = 30 * time.Minute
+		}
+		if event.duration <= 0 {
+			return nil, fmt.Errorf("invalid duration %v for %v", event.duration, item.Title)
+		}
+		if item.Date != "" {
+			if event.date, err = time.ParseInLocation("2006-01-02", item.Date, time.Local); err != nil {
+				return nil, fmt.Errorf("invalid date %v for %v: %v", item.Date, item.Title, err)
+			}
+		}
+		if len(item.Days) == 0 {
+			item.Days = []string{"daily"}
+		}
+		for _, day := range item.Days {
+			switch strings.ToLower(day) {
+			case "daily":
+				event.days = [7]bool{true, true, true, true, true, true, true}
+			case "weekdays":
+				for i := time.Monday; i <= time.Friday; i++ {
+					event.days[i] = true
+				}
+			case "weekends":
+				event.days[time.Saturday] = true
+				event.days[time.Sunday] = true
+			default:
+				i, ok := weekdays[strings.ToLower(day)]
+				if !ok {
+					return nil, fmt.Errorf("invalid day %v for %v", day, item.Title)
+				}
+				event.days[i] = true
+			}
+		}
+		events = append(events, event)
+	}
+	return events, nil
+}
+
+// ManualSource provides events from the manual events file.  The file is watched, and
+// reloaded whenever it changes, and a notification is sent on Notifications so the caller can
+// fetch again straight away.
+type ManualSource struct {
+	Notifications chan struct{}
+
+	path   string
+	mu     sync.Mutex
+	events []manualEvent
+}
+
+// NewManualSource reads the manual events file and starts watching it for changes.
+func NewManualSource(path string) (*ManualSource, error) {
+	events, err := parseManualEvents(path)
+	if err != nil {
+		return nil, err
+	}
+	source := &ManualSource{Notifications: make(chan struct{}, 1), path: path, events: events}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// Watch the directory rather than the file, since many editors save by replacing the file.
+	if err := watcher.Add(filepath.Dir(path)); err != nil {
+		watcher.Close()
+		return nil, err
+	}
+	go source.watch(watcher)
+	return source, nil
+}
+
+func (source *ManualSource) watch(watcher *fsnotify.Watcher) {
+	defer watcher.Close()
+	for {
+		select {
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return
+			}
+			if filepath.Clean(event.Name) != filepath.Clean(source.path) ||
+				!event.Has(fsnotify.Write|fsnotify.Create|fsnotify.Rename) {
+				continue
+			}
+			events, err := parseManualEvents(source.path)
+			if err != nil {
+				// Keep the previous events, so a half-saved file doesn't wipe everything out.
+				errorLog("Unable to reload manual events file %v: %v\n", source.path, err)
+				continue
+			}
+			debugLog("Reloaded %d manual events from %v\n", len(events), source.path)
+			source.mu.Lock()
+			source.events = events
+			source.mu.Unlock()
+			select {
+			case source.Notifications <- struct{}{}:
+			default:
+				// A fetch is already pending, which will pick this change up too.
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return
+			}
+			errorLog("Error watching manual events file %v: %v\n", source.path, err)
+		}
+	}
+}
+
+func (source *ManualSource) Name() string {
+	return source.path
+}
+
+func (source *ManualSource) Events(start time.Time, end time.Time) ([]*calendar.Event, error) {
+	source.mu.Lock()
+	events := source.events
+	source.mu.Unlock()
+
+	var result []*calendar.Event
+	// Start a day early to catch events that began yesterday and run past midnight.
+	firstDay := time.Date(start.Year(), start.Month(), start.Day()-1, 0, 0, 0, 0, start.Location())
+	for day := firstDay; day.Before(end); day = day.AddDate(0, 0, 1) {
+		for i, event := range events {
+			if event.date.IsZero() {
+				if !event.days[day.Weekday()] {
+					continue
+				}
+			} else if !event.date.Equal(day) {
+				continue
+			}
+			// time.Date normalizes the minutes, so this is the wall clock time even on DST changes.
+			eventStart := time.Date(day.Year(), day.Month(), day.Day(), 0, int(event.start.Minutes()), 0, 0, day.Location())
+			eventEnd := eventStart.Add(event.duration)
+			if !eventOverlaps(eventStart, eventEnd, start, end) {
+				continue
+			}
+			result = append(result, &calendar.Event{
+				Id:        fmt.Sprintf("manual-%d-%v", i, day.Format("20060102")),
+				Summary:   event.title,
+				EventType: "default",
+				Status:    "confirmed",
+				Start:     makeEventDateTime(eventStart, false),
+				End:       makeEventDateTime(eventEnd, false),
+			})
+		}
+	}
+	sortEventsByStart(result)
+	return result, nil
+}
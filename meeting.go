@@ -0,0 +1,116 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages showing video calls and in-person meetings differently.
+
+package main
+
+import (
+	"fmt"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Meeting styles are given in the config file as tables named after the kind of meeting:
+//   [meeting.video]
+//   ladder = "desk"
+//   scheme = "cyan"
+//
+//   [meeting.inPerson]
+//   ladder = "walk"
+//
+// Video calls are events with a Meet link or other conference data.  In-person meetings are
+// events without one that have a location or a room booked.  Events that are neither, such as
+// reminders to yourself, use the usual ladder.  A style that leaves out the ladder or scheme uses
+// the profile's, if there is one.
+
+// MeetingKind is whether an event is a video call or has to be walked to.
+type MeetingKind string
+
+const (
+	MeetingVideo    = MeetingKind("video")
+	MeetingInPerson = MeetingKind("inPerson")
+	// Neither a call nor somewhere to go.
+	MeetingOther = MeetingKind("")
+)
+
+// MeetingStyle is the ladder and scheme for one kind of meeting.
+type MeetingStyle struct {
+	Kind   MeetingKind
+	Ladder *Ladder
+	Scheme *Scheme
+}
+
+// Struct used for decoding a meeting style from TOML.
+type tomlMeetingStyle struct {
+	Ladder string
+	Scheme string
+}
+
+// makeMeetingStyles converts the meeting styles read from the config file.
+func makeMeetingStyles(layouts map[string]tomlMeetingStyle, ladders map[string]*Ladder, schemes map[string]*Scheme) (map[MeetingKind]*MeetingStyle, error) {
+	styles := make(map[MeetingKind]*MeetingStyle)
+	for name, layout := range layouts {
+		kind := MeetingKind(name)
+		if kind != MeetingVideo && kind != MeetingInPerson {
+			return nil, fmt.Errorf("unknown meeting kind %v, should be video or inPerson", name)
+		}
+		style := &MeetingStyle{Kind: kind}
+		if layout.Ladder != "" {
+			if style.Ladder = ladders[layout.Ladder]; style.Ladder == nil {
+				return nil, fmt.Errorf("meeting %v: unknown ladder %v", name, layout.Ladder)
+			}
+		}
+		if layout.Scheme != "" {
+			if style.Scheme = schemes[layout.Scheme]; style.Scheme == nil {
+				return nil, fmt.Errorf("meeting %v: unknown scheme %v", name, layout.Scheme)
+			}
+		}
+		styles[kind] = style
+	}
+	return styles, nil
+}
+
+// hasRoom returns true if a room or other resource is booked for the event.
+func hasRoom(event *calendar.Event) bool {
+	for _, attendee := range event.Attendees {
+		if attendee.Resource {
+			return true
+		}
+	}
+	return false
+}
+
+// meetingKind returns whether the event is a video call, an in-person meeting, or neither.
+func meetingKind(event *calendar.Event) MeetingKind {
+	if event.HangoutLink != "" {
+		return MeetingVideo
+	}
+	if event.ConferenceData != nil {
+		for _, entryPoint := range event.ConferenceData.EntryPoints {
+			if entryPoint.EntryPointType == "video" {
+				return MeetingVideo
+			}
+		}
+	}
+	if event.Location != "" || hasRoom(event) {
+		return MeetingInPerson
+	}
+	return MeetingOther
+}
+
+// meetingStyleFor returns the style for the kind of meeting the event is, or nil if there is none.
+func meetingStyleFor(event *calendar.Event, userPrefs *UserPrefs) *MeetingStyle {
+	return userPrefs.MeetingStyles[meetingKind(event)]
+}
@@ -25,6 +25,7 @@ import (
 	"os"
 	"os/user"
 	"path/filepath"
+	"strings"
 
 	"google.golang.org/api/calendar/v3"
 
@@ -36,39 +37,56 @@ import (
 
 // Connect to the Calendar API
 
-// Verify that the client credential permissions are correct before reading them.
-func loadClientCredentials(clientSecretPath string) ([]byte, error) {
+// Verify that the permissions of a file holding secrets are correct before reading it.
+func loadSecureFile(path string, description string) ([]byte, error) {
 	// Check if the file exists and is readable
-	info, err := os.Stat(clientSecretPath)
+	info, err := os.Stat(path)
 	if os.IsNotExist(err) {
-		return nil, fmt.Errorf("client secret file not found: %s", clientSecretPath)
+		return nil, fmt.Errorf("%s file not found: %s", description, path)
 	}
 	// Check if the file has secure permissions (readable only by owner)
 	if info.Mode().Perm()&077 != 0 {
-		return nil, fmt.Errorf("insecure permissions for client secret file: %s", clientSecretPath)
+		return nil, fmt.Errorf("insecure permissions for %s file: %s", description, path)
 	}
 	// Read the contents of the file
-	content, err := os.ReadFile(clientSecretPath)
+	content, err := os.ReadFile(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read client secret file: %v", err)
+		return nil, fmt.Errorf("failed to read %s file: %v", description, err)
 	}
 	return content, nil
 }
 
-func Connect() (*calendar.Service, error) {
+// Verify that the client credential permissions are correct before reading them.
+func loadClientCredentials(clientSecretPath string) ([]byte, error) {
+	return loadSecureFile(clientSecretPath, "client secret")
+}
+
+// Connect returns a Calendar service for the named account, or the default account if account is
+// empty, using the client secret at clientSecretPath.  Each account has its own cached token.
+// With freeBusyOnly, it only asks for access to free/busy times, also with its own cached token,
+// since a token is only good for the scope it was granted for.
+func Connect(account string, clientSecretPath string, freeBusyOnly bool) (*calendar.Service, error) {
 	// BEGIN GOOGLE CALENDAR API SAMPLE CODE
 	ctx := context.Background()
 
-	b, err := loadClientCredentials(*clientSecretFlag)
+	b, err := loadClientCredentials(clientSecretPath)
 	if err != nil {
 		log.Fatalf("Unable to read client secret file: %v", err)
 	}
 
-	config, err := google.ConfigFromJSON(b, calendar.CalendarReadonlyScope)
+	scope, tokenFile := calendar.CalendarReadonlyScope, "calendar-blink1.json"
+	if account != "" {
+		tokenFile = "calendar-blink1-account-" + account + ".json"
+		debugLog("Connecting to Google account %v\n", account)
+	}
+	if freeBusyOnly {
+		scope, tokenFile = calendar.CalendarFreebusyScope, strings.TrimSuffix(tokenFile, ".json")+"-freebusy.json"
+	}
+	config, err := google.ConfigFromJSON(b, scope)
 	if err != nil {
 		log.Fatalf("Unable to parse client secret file to config: %v", err)
 	}
-	client := getClient(ctx, config)
+	client := getClient(ctx, config, tokenFile)
 
 	srv, err := calendar.NewService(ctx, option.WithHTTPClient(client))
 	if err != nil {
@@ -120,13 +138,16 @@ func getTokenFromServer() string {
 
 // getClient uses a Context and Config to retrieve a Token
 // then generate a Client. It returns the generated Client.
-func getClient(ctx context.Context, config *oauth2.Config) *http.Client {
-	cacheFile, err := tokenCacheFile()
+// Modified from original Google code to take the name of the token cache file.
+func getClient(ctx context.Context, config *oauth2.Config, cacheName string) *http.Client {
+	cacheFile, err := tokenCacheFile(cacheName)
 	if err != nil {
 		log.Fatalf("Unable to get path to cached credential file. %v", err)
 	}
 	tok, err := tokenFromFile(cacheFile)
 	if err != nil {
+		// Say which token is missing, since each account is authorized separately.
+		fmt.Printf("No saved token in %v, so calblink needs to be authorized.\n", cacheFile)
 		tok = getTokenFromWeb(config)
 		saveToken(cacheFile, tok)
 	}
@@ -152,7 +173,7 @@ func getTokenFromWeb(config *oauth2.Config) *oauth2.Token {
 
 // tokenCacheFile generates credential file path/filename.
 // It returns the generated credential path/filename.
-func tokenCacheFile() (string, error) {
+func tokenCacheFile(cacheName string) (string, error) {
 	usr, err := user.Current()
 	if err != nil {
 		return "", err
@@ -160,7 +181,7 @@ func tokenCacheFile() (string, error) {
 	tokenCacheDir := filepath.Join(usr.HomeDir, ".credentials")
 	os.MkdirAll(tokenCacheDir, 0700)
 	return filepath.Join(tokenCacheDir,
-		url.QueryEscape("calendar-blink1.json")), err
+		url.QueryEscape(cacheName)), err
 }
 
 // tokenFromFile retrieves a Token from a given file path.
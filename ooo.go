@@ -0,0 +1,121 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages out of office periods.
+
+package main
+
+import (
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// How often calblink checks the calendar while idle for a long out of office period, in case
+// it is cut short.
+const outOfOfficeIdleRecheck = time.Hour
+
+// OutOfOfficeMode is what the light shows while the user is out of office.
+type OutOfOfficeMode string
+
+const (
+	// Show a steady away color.
+	OutOfOfficeAway = OutOfOfficeMode("away")
+	// Turn the light off.
+	OutOfOfficeOff = OutOfOfficeMode("off")
+)
+
+func (mode OutOfOfficeMode) isValidMode() bool {
+	switch mode {
+	case OutOfOfficeAway, OutOfOfficeOff:
+		return true
+	}
+	return false
+}
+
+// The default color shown while out of office, a dim orange.
+var defaultOutOfOfficeState = CalendarState{Name: "Away", primary: DimOrange.primary, secondary: DimOrange.secondary}
+
+// OutOfOffice is a period when the user is out of office on one calendar.
+type OutOfOffice struct {
+	Calendar string
+	Start    time.Time
+	End      time.Time
+}
+
+// Contains returns true if the period covers the given time.
+func (period OutOfOffice) Contains(t time.Time) bool {
+	return !period.Start.After(t) && period.End.After(t)
+}
+
+// outOfOfficeAt returns true if the user is out of office on the calendar at the given time.
+func outOfOfficeAt(periods []OutOfOffice, calendarID string, t time.Time) bool {
+	for _, period := range periods {
+		if period.Calendar == calendarID && period.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// awayAt returns true if the user is out of office on any calendar at the given time.
+func awayAt(periods []OutOfOffice, t time.Time) bool {
+	for _, period := range periods {
+		if period.Contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// canBeOutOfOffice returns true if the user can be out of office on a calendar: each account's
+// primary calendar, and any calendar or source that has out of office or working location events.
+// Shared calendars, rooms, feeds and the like never have them, so they can't stop the user being
+// away.
+func canBeOutOfOffice(calendarID string, events []*calendar.Event) bool {
+	if calendarID == "primary" || strings.HasSuffix(calendarID, "/primary") {
+		return true
+	}
+	for _, event := range events {
+		if event.EventType == "outOfOffice" || event.EventType == "workingLocation" {
+			return true
+		}
+	}
+	return false
+}
+
+// awayUntil returns when the user is back, if they are out of office on every one of the
+// calendars from now until at least threshold from now.  Otherwise it returns the zero time.
+func awayUntil(periods []OutOfOffice, calendarIDs []string, now time.Time, threshold time.Duration) time.Time {
+	if threshold <= 0 || len(calendarIDs) == 0 {
+		return time.Time{}
+	}
+	var back time.Time
+	for _, calendarID := range calendarIDs {
+		var calendarBack time.Time
+		for _, period := range periods {
+			if period.Calendar == calendarID && period.Contains(now) && period.End.After(calendarBack) {
+				calendarBack = period.End
+			}
+		}
+		if calendarBack.Sub(now) < threshold {
+			return time.Time{}
+		}
+		if back.IsZero() || calendarBack.Before(back) {
+			back = calendarBack
+		}
+	}
+	return back
+}
@@ -0,0 +1,133 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages deciding when to fetch events next.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// PollScheduler decides when to fetch events again after each fetch.  It polls every
+// pollInterval while a meeting is close enough to be shown, and only every farInterval
+// otherwise, making sure to fetch again as the next known event comes into view.  If the
+// Calendar API says calblink is over quota, it backs off exponentially, and it never fetches
+// more than maxPerHour times in an hour.
+type PollScheduler struct {
+	near       time.Duration
+	far        time.Duration
+	warning    time.Duration
+	maxBackoff time.Duration
+	maxPerHour int
+
+	backoff time.Duration
+	recent  []time.Time
+}
+
+func NewPollScheduler(userPrefs *UserPrefs) *PollScheduler {
+	scheduler := &PollScheduler{
+		near:       time.Duration(userPrefs.PollInterval) * time.Second,
+		far:        time.Duration(userPrefs.PollIntervalFar) * time.Second,
+		warning:    time.Duration(userPrefs.PollWarningWindow) * time.Second,
+		maxBackoff: time.Duration(userPrefs.MaxPollBackoff) * time.Second,
+		maxPerHour: userPrefs.MaxPollsPerHour,
+	}
+	if scheduler.far < scheduler.near {
+		scheduler.far = scheduler.near
+	}
+	return scheduler
+}
+
+// isQuotaError returns true if the error means calblink is making too many requests.
+func isQuotaError(err error) bool {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	if apiErr.Code == http.StatusTooManyRequests {
+		return true
+	}
+	if apiErr.Code == http.StatusForbidden {
+		for _, item := range apiErr.Errors {
+			switch item.Reason {
+			case "rateLimitExceeded", "userRateLimitExceeded", "quotaExceeded":
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Next records a fetch made at now, which returned err, and returns when to fetch next.
+func (scheduler *PollScheduler) Next(now time.Time, err error, cache *EventCache, userPrefs *UserPrefs) time.Time {
+	scheduler.recent = append(scheduler.recent, now)
+	for len(scheduler.recent) > 0 && now.Sub(scheduler.recent[0]) >= time.Hour {
+		scheduler.recent = scheduler.recent[1:]
+	}
+
+	var next time.Time
+	switch {
+	case isQuotaError(err):
+		if scheduler.backoff == 0 {
+			scheduler.backoff = scheduler.near
+		} else {
+			scheduler.backoff *= 2
+		}
+		if scheduler.backoff > scheduler.maxBackoff {
+			scheduler.backoff = scheduler.maxBackoff
+		}
+		debugLog("Over API quota, backing off for %v\n", scheduler.backoff)
+		next = now.Add(scheduler.backoff)
+	case err != nil:
+		// Retry soon, in case it was a brief outage.
+		next = now.Add(scheduler.near)
+	default:
+		scheduler.backoff = 0
+		next = scheduler.nextForEvents(now, cache, userPrefs)
+	}
+
+	if scheduler.maxPerHour > 0 && len(scheduler.recent) >= scheduler.maxPerHour {
+		earliest := scheduler.recent[len(scheduler.recent)-scheduler.maxPerHour].Add(time.Hour)
+		if next.Before(earliest) {
+			debugLog("Reached %d polls per hour, waiting until %v\n", scheduler.maxPerHour, earliest)
+			next = earliest
+		}
+	}
+	verboseLog("Next fetch at %v\n", next)
+	return next
+}
+
+// nextForEvents returns when to fetch next based on how close the next known event is.
+func (scheduler *PollScheduler) nextForEvents(now time.Time, cache *EventCache, userPrefs *UserPrefs) time.Time {
+	meetings, _ := splitFocusEvents(cache.Events, userPrefs)
+	next := nextEvent(meetings, now, userPrefs)
+	if len(next) == 0 {
+		return now.Add(scheduler.far)
+	}
+	// Start watching closely when the event comes within the warning window, which starts
+	// earlier for events that take time to get to.
+	closeAt := eventStartTime(next[0]).Add(-scheduler.warning - cache.Travel[next[0].Id])
+	if !closeAt.After(now.Add(scheduler.near)) {
+		return now.Add(scheduler.near)
+	}
+	if closeAt.Before(now.Add(scheduler.far)) {
+		return closeAt
+	}
+	return now.Add(scheduler.far)
+}
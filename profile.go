@@ -0,0 +1,103 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages the profiles that change how events are shown depending on the working location.
+
+package main
+
+import (
+	"fmt"
+)
+
+// Profiles are given in the config file as named tables:
+//   [profile.office]
+//   locations = ["office:HQ"]
+//   ladder = "full"
+//   scheme = "bright"
+//
+//   [profile.away]
+//   locations = ["custom:Client site"]
+//   off = true
+//
+// Events are shown using the profile for the working location set on their calendar when they
+// start (see workingLocations for the location format).  The profile's ladder and scheme are
+// used unless a rule picks one; with off, the events aren't shown at all.  Each location can
+// only be in one profile.
+
+// Profile is how events are shown at a set of working locations.
+type Profile struct {
+	Name   string
+	Ladder *Ladder
+	Scheme *Scheme
+	Off    bool
+}
+
+// Struct used for decoding a profile from TOML.
+type tomlProfile struct {
+	Locations []string
+	Ladder    string
+	Scheme    string
+	Off       bool
+}
+
+// makeProfiles converts the profiles read from the config file into a map from each working
+// location to its profile.
+func makeProfiles(layouts map[string]tomlProfile, ladders map[string]*Ladder, schemes map[string]*Scheme) (map[WorkSite]*Profile, error) {
+	profiles := make(map[WorkSite]*Profile)
+	for name, layout := range layouts {
+		profile := &Profile{Name: name, Off: layout.Off}
+		if layout.Ladder != "" {
+			if profile.Ladder = ladders[layout.Ladder]; profile.Ladder == nil {
+				return nil, fmt.Errorf("profile %v: unknown ladder %v", name, layout.Ladder)
+			}
+		}
+		if layout.Scheme != "" {
+			if profile.Scheme = schemes[layout.Scheme]; profile.Scheme == nil {
+				return nil, fmt.Errorf("profile %v: unknown scheme %v", name, layout.Scheme)
+			}
+		}
+		for _, location := range layout.Locations {
+			site := makeWorkSite(location)
+			if other, ok := profiles[site]; ok {
+				return nil, fmt.Errorf("profile %v: location %v is already in profile %v", name, location, other.Name)
+			}
+			profiles[site] = profile
+		}
+	}
+	return profiles, nil
+}
+
+// profileForLocations returns the profile for the first of the working locations that has one,
+// or nil if none do.
+func profileForLocations(locations []WorkSite, userPrefs *UserPrefs) *Profile {
+	for _, location := range locations {
+		if profile, ok := userPrefs.Profiles[location]; ok {
+			return profile
+		}
+	}
+	return nil
+}
+
+// profileByName returns the named profile, or nil if there is none.
+func profileByName(name string, userPrefs *UserPrefs) *Profile {
+	if name == "" {
+		return nil
+	}
+	for _, profile := range userPrefs.Profiles {
+		if profile.Name == name {
+			return profile
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages starting the warnings for events when their reminders fire.
+
+package main
+
+import (
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// applyDefaultReminders replaces the "use the calendar's default" marker on events with the
+// calendar's default reminders, so that events carry their own reminders wherever they came from.
+func applyDefaultReminders(items []*calendar.Event, defaults []*calendar.EventReminder) {
+	for _, item := range items {
+		if item.Reminders != nil && item.Reminders.UseDefault {
+			item.Reminders = &calendar.EventReminders{Overrides: defaults}
+		}
+	}
+}
+
+// popupReminder returns how long before the event its earliest popup reminder fires, and false
+// if it has no popup reminders.  Email reminders aren't counted, since they aren't a prompt to
+// leave for the meeting.  An event whose reminders were all removed returns zero and true, so
+// it gets no warnings before it starts.
+func popupReminder(event *calendar.Event) (time.Duration, bool) {
+	if event.Reminders == nil {
+		return 0, false
+	}
+	found := false
+	earliest := time.Duration(0)
+	for _, reminder := range event.Reminders.Overrides {
+		if reminder.Method != "popup" {
+			continue
+		}
+		if before := time.Duration(reminder.Minutes) * time.Minute; !found || before > earliest {
+			earliest = before
+		}
+		found = true
+	}
+	if !found && !event.Reminders.UseDefault && len(event.Reminders.Overrides) == 0 {
+		return 0, true
+	}
+	return earliest, found
+}
+
+// ladderState returns the state to show for an event starting after delta.  With useReminders,
+// events with a popup reminder are anchored to it instead of to where the ladder starts.
+func ladderState(ladder *Ladder, event *calendar.Event, delta time.Duration, scheme *Scheme, userPrefs *UserPrefs) CalendarState {
+	if userPrefs.UseReminders {
+		if reminder, ok := popupReminder(event); ok {
+			return ladder.StateFrom(delta, reminder, scheme)
+		}
+	}
+	return ladder.State(delta, scheme)
+}
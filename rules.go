@@ -0,0 +1,305 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages the rules that decide which events are shown, and how.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Rules are given in the config file as an ordered list of tables:
+//   [[rule]]
+//   name = "skip all-hands"
+//   action = "exclude"
+//   title = "(?i)all.hands"
+//   organizer = "ceo@example.com"
+//   minAttendees = 50
+//   maxAttendees = 500
+//   calendar = "team@group.calendar.google.com"
+//   eventType = "default"
+//   location = "Auditorium"
+//   description = "optional"
+//   visibility = "public"
+//   transparency = "opaque"
+//   minDuration = 30
+//   maxDuration = 120
+//   ladder = "gentle"
+//   scheme = "quiet"
+//
+// Every condition given must match for the rule to match; conditions that aren't given match
+// anything.  The first matching rule with an action decides whether the event is included or
+// excluded, and events that match no rule are included.  The first matching rule with a ladder
+// or scheme (see ladder.go) picks how the light shows the event.  A rule with a ladder or scheme
+// and no action doesn't affect whether the event is shown; otherwise the action defaults to
+// exclude.  Title, location and description are regular expressions, which match anywhere in
+// the text unless anchored.  Durations are in minutes.  The excludes and excludePrefixes options
+// are checked after all the rules.
+
+// RuleAction is what a rule does with the events it matches.
+type RuleAction string
+
+const (
+	RuleInclude = RuleAction("include")
+	RuleExclude = RuleAction("exclude")
+)
+
+// Rule is a single rule for filtering or styling events.
+type Rule struct {
+	Name         string
+	Action       RuleAction
+	Title        *regexp.Regexp
+	Organizer    string
+	MinAttendees *int
+	MaxAttendees *int
+	Calendar     string
+	EventType    string
+	Location     *regexp.Regexp
+	Description  *regexp.Regexp
+	Visibility   string
+	Transparency string
+	MinDuration  time.Duration
+	MaxDuration  time.Duration
+	Ladder       *Ladder
+	Scheme       *Scheme
+}
+
+// Struct used for decoding a rule from TOML.
+type tomlRule struct {
+	Name         string
+	Action       string
+	Title        string
+	Organizer    string
+	MinAttendees *int64
+	MaxAttendees *int64
+	Calendar     string
+	EventType    string
+	Location     string
+	Description  string
+	Visibility   string
+	Transparency string
+	MinDuration  int64
+	MaxDuration  int64
+	Ladder       string
+	Scheme       string
+}
+
+func compileRuleRegexp(field string, expr string) (*regexp.Regexp, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("bad %v pattern: %v", field, err)
+	}
+	return re, nil
+}
+
+func optionalInt(value *int64) *int {
+	if value == nil {
+		return nil
+	}
+	i := int(*value)
+	return &i
+}
+
+// makeRule converts a rule read from the config file.  index is its position in the list,
+// used to name it if it has no name.
+func makeRule(index int, layout tomlRule, ladders map[string]*Ladder, schemes map[string]*Scheme) (*Rule, error) {
+	rule := &Rule{
+		Name:         layout.Name,
+		Action:       RuleAction(layout.Action),
+		Organizer:    strings.ToLower(layout.Organizer),
+		MinAttendees: optionalInt(layout.MinAttendees),
+		MaxAttendees: optionalInt(layout.MaxAttendees),
+		Calendar:     layout.Calendar,
+		EventType:    layout.EventType,
+		Visibility:   layout.Visibility,
+		Transparency: layout.Transparency,
+		MinDuration:  time.Duration(layout.MinDuration) * time.Minute,
+		MaxDuration:  time.Duration(layout.MaxDuration) * time.Minute,
+	}
+	if rule.Name == "" {
+		rule.Name = fmt.Sprintf("rule %d", index+1)
+	}
+	if layout.Ladder != "" {
+		if rule.Ladder = ladders[layout.Ladder]; rule.Ladder == nil {
+			return nil, fmt.Errorf("%v: unknown ladder %v", rule.Name, layout.Ladder)
+		}
+	}
+	if layout.Scheme != "" {
+		if rule.Scheme = schemes[layout.Scheme]; rule.Scheme == nil {
+			return nil, fmt.Errorf("%v: unknown scheme %v", rule.Name, layout.Scheme)
+		}
+	}
+	if rule.Action == "" && rule.Ladder == nil && rule.Scheme == nil {
+		rule.Action = RuleExclude
+	}
+	if rule.Action != "" && rule.Action != RuleInclude && rule.Action != RuleExclude {
+		return nil, fmt.Errorf("%v: unknown action %v", rule.Name, layout.Action)
+	}
+	var err error
+	if rule.Title, err = compileRuleRegexp("title", layout.Title); err != nil {
+		return nil, fmt.Errorf("%v: %v", rule.Name, err)
+	}
+	if rule.Location, err = compileRuleRegexp("location", layout.Location); err != nil {
+		return nil, fmt.Errorf("%v: %v", rule.Name, err)
+	}
+	if rule.Description, err = compileRuleRegexp("description", layout.Description); err != nil {
+		return nil, fmt.Errorf("%v: %v", rule.Name, err)
+	}
+	return rule, nil
+}
+
+// makeRules converts the rules read from the config file, in order.
+func makeRules(layouts []tomlRule, ladders map[string]*Ladder, schemes map[string]*Scheme) ([]*Rule, error) {
+	var rules []*Rule
+	for i, layout := range layouts {
+		rule, err := makeRule(i, layout, ladders, schemes)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, rule)
+	}
+	return rules, nil
+}
+
+// attendeeCount returns the number of people invited to an event, not counting rooms.
+func attendeeCount(event *calendar.Event) int {
+	count := 0
+	for _, attendee := range event.Attendees {
+		if !attendee.Resource {
+			count++
+		}
+	}
+	return count
+}
+
+// valueOrDefault returns value, or def if value is empty.  The API leaves some fields empty
+// when they have their default value.
+func valueOrDefault(value string, def string) string {
+	if value == "" {
+		return def
+	}
+	return value
+}
+
+// Matches returns true if the event, from the given calendar, meets every condition of the rule.
+func (rule *Rule) Matches(event *calendar.Event, calendarID string) bool {
+	if rule.Title != nil && !rule.Title.MatchString(event.Summary) {
+		return false
+	}
+	if rule.Organizer != "" && (event.Organizer == nil || strings.ToLower(event.Organizer.Email) != rule.Organizer) {
+		return false
+	}
+	count := attendeeCount(event)
+	if rule.MinAttendees != nil && count < *rule.MinAttendees {
+		return false
+	}
+	if rule.MaxAttendees != nil && count > *rule.MaxAttendees {
+		return false
+	}
+	if rule.Calendar != "" && calendarID != rule.Calendar {
+		return false
+	}
+	if rule.EventType != "" && valueOrDefault(event.EventType, "default") != rule.EventType {
+		return false
+	}
+	if rule.Location != nil && !rule.Location.MatchString(event.Location) {
+		return false
+	}
+	if rule.Description != nil && !rule.Description.MatchString(event.Description) {
+		return false
+	}
+	if rule.Visibility != "" && valueOrDefault(event.Visibility, "default") != rule.Visibility {
+		return false
+	}
+	if rule.Transparency != "" && valueOrDefault(event.Transparency, "opaque") != rule.Transparency {
+		return false
+	}
+	if rule.MinDuration > 0 || rule.MaxDuration > 0 {
+		duration := eventEndTime(event).Sub(eventStartTime(event))
+		if rule.MinDuration > 0 && duration < rule.MinDuration {
+			return false
+		}
+		if rule.MaxDuration > 0 && duration > rule.MaxDuration {
+			return false
+		}
+	}
+	return true
+}
+
+// applyRules returns the name of the rule that decides whether the event is shown, and whether
+// it is included.  If no rule matches, the name is empty and the event is included.
+func applyRules(event *calendar.Event, calendarID string, userPrefs *UserPrefs) (string, bool) {
+	for _, rule := range userPrefs.Rules {
+		if rule.Action != "" && rule.Matches(event, calendarID) {
+			return rule.Name, rule.Action == RuleInclude
+		}
+	}
+	settings := settingsFor(calendarID, userPrefs)
+	if settings.Excludes[event.Summary] {
+		return "excludes", false
+	}
+	for _, prefix := range settings.ExcludePrefixes {
+		if strings.HasPrefix(event.Summary, prefix) {
+			return fmt.Sprintf("excludePrefixes '%v'", prefix), false
+		}
+	}
+	return "", true
+}
+
+// styleForEvent returns the ladder and scheme to show the event with, and the name of the rule,
+// meeting style or profile that picked them, or "" if none did.  Rules take priority over the
+// style for the kind of meeting, and that over the profile, which may be nil.  One that only sets
+// the ladder or the scheme takes the other from the next one down.
+func styleForEvent(event *calendar.Event, calendarID string, profile *Profile, userPrefs *UserPrefs) (*Ladder, *Scheme, string) {
+	var ladder *Ladder
+	var scheme *Scheme
+	name := ""
+	apply := func(styleLadder *Ladder, styleScheme *Scheme, styleName string) {
+		if styleLadder == nil && styleScheme == nil {
+			return
+		}
+		if styleLadder != nil {
+			ladder = styleLadder
+		}
+		if styleScheme != nil {
+			scheme = styleScheme
+		}
+		name = styleName
+	}
+	if profile != nil {
+		apply(profile.Ladder, profile.Scheme, "profile "+profile.Name)
+	}
+	if style := meetingStyleFor(event, userPrefs); style != nil {
+		apply(style.Ladder, style.Scheme, "meeting "+string(style.Kind))
+	}
+	for _, rule := range userPrefs.Rules {
+		if (rule.Ladder != nil || rule.Scheme != nil) && rule.Matches(event, calendarID) {
+			apply(rule.Ladder, rule.Scheme, rule.Name)
+			break
+		}
+	}
+	if ladder == nil {
+		ladder = defaultLadder
+	}
+	return ladder, scheme, name
+}
@@ -0,0 +1,169 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages calendar sources other than Google Calendar.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// EventSource is a source of events that isn't a Google Calendar.  Sources return their
+// events converted to the Calendar API's event type, so that they go through exactly the
+// same filtering as events from Google Calendar.
+type EventSource interface {
+	// Name returns a human-readable name for the source, for logging.
+	Name() string
+	// Events returns all events that overlap the window from start to end, sorted by start time.
+	Events(start time.Time, end time.Time) ([]*calendar.Event, error)
+}
+
+// multiCalendarSource is implemented by sources that read several calendars at once, so that
+// each event can be matched to the calendar it came from rather than to the source.
+type multiCalendarSource interface {
+	EventCalendar(event *calendar.Event) string
+}
+
+// sourceCalendar returns the calendar ID that rules and settings see for an event from source.
+func sourceCalendar(source EventSource, event *calendar.Event) string {
+	if multi, ok := source.(multiCalendarSource); ok {
+		return multi.EventCalendar(event)
+	}
+	return source.Name()
+}
+
+// makeEventSources creates the event sources listed in the user preferences other than the Google
+// calendars that are listed directly.  srv is the Calendar service for those, if there are any,
+// which free/busy calendars share.
+func makeEventSources(userPrefs *UserPrefs, srv *calendar.Service) []EventSource {
+	var sources []EventSource
+	for _, location := range userPrefs.ICSCalendars {
+		sources = append(sources, NewICSSource(location, userPrefs.SelfEmails))
+	}
+	if len(userPrefs.CalDAVCalendars) > 0 {
+		password := ""
+		if userPrefs.CalDAVPasswordFile != "" {
+			b, err := loadSecureFile(userPrefs.CalDAVPasswordFile, "CalDAV password")
+			if err != nil {
+				log.Fatalf("Unable to read CalDAV password: %v", err)
+			}
+			password = strings.TrimSpace(string(b))
+		}
+		for _, calendarURL := range userPrefs.CalDAVCalendars {
+			sources = append(sources, NewCalDAVSource(calendarURL, userPrefs.CalDAVUsername, password, userPrefs.SelfEmails))
+		}
+	}
+	if userPrefs.ManualEventsFile != "" {
+		source, err := NewManualSource(userPrefs.ManualEventsFile)
+		if err != nil {
+			log.Fatalf("Unable to read manual events file %v: %v", userPrefs.ManualEventsFile, err)
+		}
+		sources = append(sources, source)
+	}
+	if len(userPrefs.GraphCalendars) > 0 {
+		client := ConnectGraph(userPrefs.GraphClientSecret)
+		for _, calendarID := range userPrefs.GraphCalendars {
+			sources = append(sources, NewGraphSource(userPrefs.GraphEndpoint, calendarID, client))
+		}
+	}
+	if len(userPrefs.FreeBusyCalendars) > 0 {
+		if srv == nil {
+			var err error
+			srv, err = Connect("", *clientSecretFlag, userPrefs.FreeBusyScope)
+			if err != nil {
+				log.Fatalf("Unable to retrieve Calendar client: %v", err)
+			}
+		}
+		sources = append(sources, NewFreeBusySource(srv, userPrefs.FreeBusyCalendars))
+	}
+	return sources
+}
+
+// makeEventDateTime converts a time into the Calendar API's representation.  All-day
+// events only set the date, matching what Google Calendar returns for them.
+func makeEventDateTime(t time.Time, allDay bool) *calendar.EventDateTime {
+	if allDay {
+		return &calendar.EventDateTime{Date: t.Format("2006-01-02")}
+	}
+	return &calendar.EventDateTime{DateTime: t.Format(time.RFC3339)}
+}
+
+// eventOverlaps returns true if an event running from eventStart to eventEnd overlaps the window from start to end.
+func eventOverlaps(eventStart time.Time, eventEnd time.Time, start time.Time, end time.Time) bool {
+	return eventStart.Before(end) && eventEnd.After(start)
+}
+
+// eventStartTime returns the start time of an event.  All-day events start at midnight local
+// time, and events with unparseable times are treated as starting at the zero time.
+func eventStartTime(event *calendar.Event) time.Time {
+	return parseEventDateTime(event.Start)
+}
+
+// eventEndTime returns the end time of an event, with the same conventions as eventStartTime.
+func eventEndTime(event *calendar.Event) time.Time {
+	return parseEventDateTime(event.End)
+}
+
+func parseEventDateTime(dateTime *calendar.EventDateTime) time.Time {
+	if dateTime == nil {
+		return time.Time{}
+	}
+	if dateTime.DateTime != "" {
+		t, err := time.Parse(time.RFC3339, dateTime.DateTime)
+		if err != nil {
+			debugLog("Unable to parse event time %v: %v\n", dateTime.DateTime, err)
+		}
+		return t
+	}
+	t, err := time.ParseInLocation("2006-01-02", dateTime.Date, time.Local)
+	if err != nil {
+		debugLog("Unable to parse event date %v: %v\n", dateTime.Date, err)
+	}
+	return t
+}
+
+// sortEventsByStart sorts events by their start time.
+func sortEventsByStart(events []*calendar.Event) {
+	sort.SliceStable(events, func(i, j int) bool {
+		return eventStartTime(events[i]).Before(eventStartTime(events[j]))
+	})
+}
+
+// redactURL strips everything but the scheme and host from a URL, since calendar feed URLs
+// often contain secret tokens that shouldn't end up in logs.  Non-URLs are returned unchanged.
+func redactURL(location string) string {
+	if u, err := url.Parse(location); err == nil && u.Host != "" {
+		return u.Scheme + "://" + u.Host + "/..."
+	}
+	return location
+}
+
+// redactURLError returns an error without the full URL, for errors from HTTP requests, which
+// include the URL they were for.  Other errors are returned unchanged.
+func redactURLError(err error) error {
+	var urlErr *url.Error
+	if errors.As(err, &urlErr) {
+		return fmt.Errorf("%v %v: %v", urlErr.Op, redactURL(urlErr.URL), urlErr.Err)
+	}
+	return err
+}
@@ -0,0 +1,161 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages incremental sync of Google calendars.
+
+package main
+
+import (
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+	"google.golang.org/api/googleapi"
+)
+
+// How far past the end of the requested window a full sync fetches events.  Incremental syncs
+// only report changes, so once the requested window passes the end of this, another full sync
+// is done to pick up events that were already on the calendar but outside the window.
+const syncWindow = 24 * time.Hour
+
+// calendarStore is the local copy of a single calendar's events.
+type calendarStore struct {
+	syncToken   string
+	windowStart time.Time
+	windowEnd   time.Time
+	events      map[string]*calendar.Event
+}
+
+// SyncEngine keeps a local store of events for each calendar, updated using the Calendar
+// API's sync tokens so that each poll only transfers the events that changed.
+type SyncEngine struct {
+	srv       *calendar.Service
+	maxEvents int
+	mu        sync.Mutex
+	stores    map[string]*calendarStore
+}
+
+func NewSyncEngine(srv *calendar.Service, maxEvents int) *SyncEngine {
+	return &SyncEngine{
+		srv:       srv,
+		maxEvents: maxEvents,
+		stores:    make(map[string]*calendarStore),
+	}
+}
+
+// List syncs the given calendar and returns the stored events that overlap the window from
+// start to end, sorted by start time.
+func (engine *SyncEngine) List(calendarID string, start time.Time, end time.Time) ([]*calendar.Event, error) {
+	engine.mu.Lock()
+	defer engine.mu.Unlock()
+	store, err := engine.sync(calendarID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	var events []*calendar.Event
+	for id, event := range store.events {
+		eventEnd := eventEndTime(event)
+		if eventEnd.Before(start) {
+			// It's over, so it will never be needed again.
+			delete(store.events, id)
+			continue
+		}
+		if eventOverlaps(eventStartTime(event), eventEnd, start, end) {
+			events = append(events, event)
+		}
+	}
+	sortEventsByStart(events)
+	return events, nil
+}
+
+// sync brings the store for a calendar up to date, doing a full sync if there is no usable
+// sync token or the store doesn't cover the window.
+func (engine *SyncEngine) sync(calendarID string, start time.Time, end time.Time) (*calendarStore, error) {
+	store := engine.stores[calendarID]
+	if store != nil && store.syncToken != "" && !end.After(store.windowEnd) {
+		err := engine.incrementalSync(calendarID, store)
+		if err == nil {
+			return store, nil
+		}
+		var apiErr *googleapi.Error
+		if !errors.As(err, &apiErr) || apiErr.Code != http.StatusGone {
+			return nil, err
+		}
+		debugLog("Sync token for calendar %v expired, doing a full sync\n", calendarID)
+	}
+	store, err := engine.fullSync(calendarID, start, end)
+	if err != nil {
+		return nil, err
+	}
+	engine.stores[calendarID] = store
+	return store, nil
+}
+
+func (engine *SyncEngine) fullSync(calendarID string, start time.Time, end time.Time) (*calendarStore, error) {
+	store := &calendarStore{
+		windowStart: start,
+		windowEnd:   end.Add(syncWindow),
+		events:      make(map[string]*calendar.Event),
+	}
+	call := engine.srv.Events.List(calendarID).SingleEvents(true).
+		TimeMin(store.windowStart.Format(time.RFC3339)).TimeMax(store.windowEnd.Format(time.RFC3339)).
+		EventTypes(calendarEventTypes...)
+	// Sync listings can't be ordered, so a cap would drop arbitrary events, and it would also lose
+	// the sync token.  The window is only a day past the lookahead, so every page is read, with a
+	// warning if there are more events than the cap.
+	items, syncToken, err := listAllPages(call, calendarID, 0)
+	if err != nil {
+		return nil, err
+	}
+	if engine.maxEvents > 0 && len(items) > engine.maxEvents {
+		errorLog("Calendar %v has %d events in the sync window, more than maxEventsPerCalendar %d\n",
+			calendarID, len(items), engine.maxEvents)
+	}
+	store.apply(items)
+	store.syncToken = syncToken
+	if store.syncToken == "" {
+		// Without a sync token there's nothing to increment from, so this store will be
+		// replaced by another full sync on the next poll.
+		debugLog("No sync token returned for calendar %v\n", calendarID)
+	}
+	debugLog("Full sync of calendar %v: %d events until %v\n", calendarID, len(store.events), store.windowEnd)
+	return store, nil
+}
+
+func (engine *SyncEngine) incrementalSync(calendarID string, store *calendarStore) error {
+	call := engine.srv.Events.List(calendarID).SingleEvents(true).
+		EventTypes(calendarEventTypes...).SyncToken(store.syncToken)
+	// Changes are never capped, since dropping any would leave the store wrong until the next full sync.
+	items, syncToken, err := listAllPages(call, calendarID, 0)
+	if err != nil {
+		return err
+	}
+	store.apply(items)
+	store.syncToken = syncToken
+	verboseLog("Incremental sync of calendar %v: %d changes\n", calendarID, len(items))
+	return nil
+}
+
+// apply updates the store with changed events.  Cancelled events are deleted.
+func (store *calendarStore) apply(items []*calendar.Event) {
+	for _, event := range items {
+		if event.Status == "cancelled" {
+			delete(store.events, event.Id)
+		} else {
+			store.events[event.Id] = event
+		}
+	}
+}
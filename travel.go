@@ -0,0 +1,98 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages the travel time to meetings elsewhere.
+
+package main
+
+import (
+	"strings"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Travel times are given in the config file as a table of minutes:
+//   [travel]
+//   "Building 42" = 10
+//   "Downtown" = 30
+//
+// Each key is matched, ignoring case, against the names of the rooms booked for an event and
+// against its location.  The warnings for the event start earlier by the longest matching
+// travel time, so that they tell the user when to leave, and events are fetched that much further
+// ahead so those warnings aren't missed.  There is no travel time when the
+// working location set for the event is home.
+
+// makeTravelTimes converts the travel table read from the config file, with the keys lowercased
+// for matching.
+func makeTravelTimes(layout map[string]int64) map[string]time.Duration {
+	travel := make(map[string]time.Duration)
+	for place, minutes := range layout {
+		travel[strings.ToLower(place)] = time.Duration(minutes) * time.Minute
+	}
+	return travel
+}
+
+// longestTravelTime returns the longest of the travel times.
+func longestTravelTime(userPrefs *UserPrefs) time.Duration {
+	longest := time.Duration(0)
+	for _, travel := range userPrefs.Travel {
+		if travel > longest {
+			longest = travel
+		}
+	}
+	return longest
+}
+
+// travelTime returns how long it takes to get to the event from the given working locations.
+func travelTime(event *calendar.Event, locations []WorkSite, userPrefs *UserPrefs) time.Duration {
+	if len(userPrefs.Travel) == 0 {
+		return 0
+	}
+	for _, location := range locations {
+		if location.SiteType == WorkSiteHome {
+			return 0
+		}
+	}
+	places := []string{strings.ToLower(event.Location)}
+	for _, attendee := range event.Attendees {
+		if attendee.Resource {
+			places = append(places, strings.ToLower(attendee.DisplayName))
+		}
+	}
+	longest := time.Duration(0)
+	for place, travel := range userPrefs.Travel {
+		for _, name := range places {
+			if name != "" && strings.Contains(name, place) && travel > longest {
+				longest = travel
+			}
+		}
+	}
+	return longest
+}
+
+// leaveByDelta returns the time until the event to look up on its ladder, given the time until
+// it starts and the travel time to it.  Only the warnings move earlier: once it's time to leave
+// the last warning is shown until the meeting starts, and the stages from the start on are
+// unchanged.
+func leaveByDelta(delta time.Duration, travel time.Duration) time.Duration {
+	if travel <= 0 || delta <= 0 {
+		return delta
+	}
+	if delta <= travel {
+		// Just before the start, where the last warning stage is.
+		return time.Nanosecond
+	}
+	return delta - travel
+}
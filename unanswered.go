@@ -0,0 +1,81 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages how events that haven't been answered yet are shown.
+
+package main
+
+import (
+	blink1 "github.com/kazrakcom/go-blink1"
+	"google.golang.org/api/calendar/v3"
+)
+
+// UnansweredMode is how events the user hasn't accepted or declined are shown.
+type UnansweredMode string
+
+const (
+	// Show them like any other event.
+	UnansweredNormal = UnansweredMode("normal")
+	// Show the ladder at a quarter of the brightness.
+	UnansweredDim = UnansweredMode("dim")
+	// Show a dim version of the ladder's color on LED 1 and the full color on LED 2.
+	UnansweredStriped = UnansweredMode("striped")
+)
+
+func (mode UnansweredMode) isValidMode() bool {
+	switch mode {
+	case UnansweredNormal, UnansweredDim, UnansweredStriped:
+		return true
+	}
+	return false
+}
+
+// isUnanswered returns true if the user's response to the event is tentative or not given yet.
+// Events without the user as an attendee are their own, so they count as answered.
+func isUnanswered(event *calendar.Event) bool {
+	for _, attendee := range event.Attendees {
+		if attendee.Self {
+			return attendee.ResponseStatus == "tentative" || attendee.ResponseStatus == "needsAction"
+		}
+	}
+	return false
+}
+
+// dimColor returns the color at a quarter of its brightness.
+func dimColor(color blink1.State) blink1.State {
+	color.Red /= 4
+	color.Green /= 4
+	color.Blue /= 4
+	return color
+}
+
+// unansweredState returns the state to show for an unanswered event in the given mode.  Both
+// modes dim LED 1, since that is the only color left for stages that flash a single color or
+// for an event shown alongside another with CombineStates.
+func unansweredState(in CalendarState, mode UnansweredMode) CalendarState {
+	if in == Black {
+		return in
+	}
+	out := in
+	switch mode {
+	case UnansweredDim:
+		out.Name = in.Name + " (dim)"
+		out.primary = dimColor(in.primary)
+		out.secondary = dimColor(in.secondary)
+	case UnansweredStriped:
+		out.Name = in.Name + " (striped)"
+		out.primary = dimColor(in.primary)
+	}
+	return out
+}
@@ -0,0 +1,337 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file manages push notifications of calendar changes through Events.Watch channels.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// Channels are renewed this long before they expire, so a slow renewal doesn't leave a gap.
+const channelRenewMargin = time.Hour
+
+// How often the watcher checks whether channels need to be registered or renewed.
+const channelCheckInterval = time.Minute
+
+// How long Stop waits for notifications that are being handled before closing the listener.
+const watchShutdownTimeout = 5 * time.Second
+
+// watchChannel is a registered notification channel for a single calendar.
+type watchChannel struct {
+	id         string
+	resourceID string
+	expiration time.Time
+}
+
+// CalendarWatcher registers a notification channel for each calendar and listens for the
+// change notifications Google Calendar sends to it.  Changed calendars are recorded until
+// TakeChanged is called, and a notification is sent on Notifications so the caller can fetch
+// again straight away.
+type CalendarWatcher struct {
+	Notifications chan struct{}
+
+	srv       *calendar.Service
+	calendars []string
+	address   string
+	token     string
+
+	mu       sync.Mutex
+	channels map[string]*watchChannel
+	// The calendar each channel ID belongs to, including channels that have been replaced
+	// but may still deliver a last notification.
+	channelCalendars map[string]string
+	changed          map[string]bool
+	serverFailed     bool
+	server           *http.Server
+	// Closed by Stop, so that channels are no longer renewed.
+	done chan struct{}
+}
+
+// NewCalendarWatcher creates a watcher for the given calendars.  address is the public HTTPS
+// URL that Google Calendar will post notifications to, either calblink's own listener or a
+// forwarder that relays the requests to it.
+func NewCalendarWatcher(srv *calendar.Service, calendars []string, address string) *CalendarWatcher {
+	return &CalendarWatcher{
+		Notifications:    make(chan struct{}, 1),
+		srv:              srv,
+		calendars:        calendars,
+		address:          address,
+		token:            randomID(),
+		channels:         make(map[string]*watchChannel),
+		channelCalendars: make(map[string]string),
+		changed:          make(map[string]bool),
+		done:             make(chan struct{}),
+	}
+}
+
+func randomID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		errorLog("Unable to generate random ID: %v\n", err)
+	}
+	return hex.EncodeToString(b)
+}
+
+// Start starts listening for notifications on listenAddress and registers the channels.  If
+// certFile and keyFile are set the listener uses HTTPS; otherwise it uses plain HTTP, which is
+// only appropriate behind a forwarder or proxy that terminates HTTPS.
+func (watcher *CalendarWatcher) Start(listenAddress string, certFile string, keyFile string) {
+	server := &http.Server{Addr: listenAddress, Handler: watcher}
+	watcher.mu.Lock()
+	watcher.server = server
+	watcher.mu.Unlock()
+	go func() {
+		var err error
+		if certFile != "" && keyFile != "" {
+			err = server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		if errors.Is(err, http.ErrServerClosed) {
+			return
+		}
+		errorLog("Push notification listener stopped, falling back to polling: %v\n", err)
+		watcher.mu.Lock()
+		watcher.serverFailed = true
+		watcher.mu.Unlock()
+	}()
+	go watcher.maintainChannels()
+}
+
+// Active returns true if notifications can currently be received for every calendar.  While
+// it is false, the caller should poll at the normal rate.
+func (watcher *CalendarWatcher) Active() bool {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	if watcher.serverFailed {
+		return false
+	}
+	now := time.Now()
+	for _, calendarID := range watcher.calendars {
+		channel := watcher.channels[calendarID]
+		if channel == nil || channel.expiration.Before(now) {
+			return false
+		}
+	}
+	return true
+}
+
+// TakeChanged returns true if a change to the calendar has been notified since the last call.
+func (watcher *CalendarWatcher) TakeChanged(calendarID string) bool {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	changed := watcher.changed[calendarID]
+	delete(watcher.changed, calendarID)
+	return changed
+}
+
+func (watcher *CalendarWatcher) markChanged(calendarID string) {
+	watcher.mu.Lock()
+	defer watcher.mu.Unlock()
+	watcher.changed[calendarID] = true
+}
+
+func (watcher *CalendarWatcher) maintainChannels() {
+	for {
+		for _, calendarID := range watcher.calendars {
+			watcher.mu.Lock()
+			channel := watcher.channels[calendarID]
+			watcher.mu.Unlock()
+			if channel != nil && time.Until(channel.expiration) > channelRenewMargin {
+				continue
+			}
+			select {
+			case <-watcher.done:
+				return
+			default:
+			}
+			if err := watcher.register(calendarID); err != nil {
+				errorLog("Unable to register notification channel for calendar %v: %v\n", calendarID, err)
+				continue
+			}
+			if channel != nil {
+				watcher.stopChannel(channel)
+			}
+		}
+		select {
+		case <-watcher.done:
+			return
+		case <-time.After(channelCheckInterval):
+		}
+	}
+}
+
+func (watcher *CalendarWatcher) register(calendarID string) error {
+	request := &calendar.Channel{
+		Id:      randomID(),
+		Type:    "web_hook",
+		Address: watcher.address,
+		Token:   watcher.token,
+	}
+	response, err := watcher.srv.Events.Watch(calendarID, request).EventTypes(calendarEventTypes...).Do()
+	if err != nil {
+		return err
+	}
+	channel := &watchChannel{
+		id:         response.Id,
+		resourceID: response.ResourceId,
+		expiration: time.UnixMilli(response.Expiration),
+	}
+	debugLog("Registered notification channel %v for calendar %v, expiring %v\n", channel.id, calendarID, channel.expiration)
+	watcher.mu.Lock()
+	watcher.channels[calendarID] = channel
+	watcher.channelCalendars[channel.id] = calendarID
+	watcher.mu.Unlock()
+	return nil
+}
+
+// stopChannel unregisters a channel.  Its ID is left in channelCalendars, so a notification
+// that was already on its way is still matched to the calendar.
+func (watcher *CalendarWatcher) stopChannel(channel *watchChannel) {
+	err := watcher.srv.Channels.Stop(&calendar.Channel{Id: channel.id, ResourceId: channel.resourceID}).Do()
+	if err != nil {
+		debugLog("Unable to stop notification channel %v: %v\n", channel.id, err)
+	}
+}
+
+// Stop unregisters all channels, so Google stops sending notifications after calblink exits,
+// and shuts down the listener.
+func (watcher *CalendarWatcher) Stop() {
+	watcher.mu.Lock()
+	select {
+	case <-watcher.done:
+	default:
+		close(watcher.done)
+	}
+	channels := watcher.channels
+	watcher.channels = make(map[string]*watchChannel)
+	server := watcher.server
+	watcher.server = nil
+	watcher.mu.Unlock()
+	for _, channel := range channels {
+		watcher.stopChannel(channel)
+	}
+	if server != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), watchShutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			debugLog("Unable to shut down push notification listener: %v\n", err)
+		}
+	}
+}
+
+// ServeHTTP receives notifications from Google Calendar.
+func (watcher *CalendarWatcher) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	token := req.Header.Get("X-Goog-Channel-Token")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(watcher.token)) != 1 {
+		debugLog("Ignoring notification with bad token\n")
+		w.WriteHeader(http.StatusForbidden)
+		return
+	}
+	channelID := req.Header.Get("X-Goog-Channel-ID")
+	state := req.Header.Get("X-Goog-Resource-State")
+	watcher.mu.Lock()
+	calendarID, ok := watcher.channelCalendars[channelID]
+	watcher.mu.Unlock()
+	// Acknowledge everything with a valid token, even unknown channels, so Google doesn't retry.
+	w.WriteHeader(http.StatusOK)
+	if !ok {
+		debugLog("Ignoring notification for unknown channel %v\n", channelID)
+		return
+	}
+	debugLog("Notification for calendar %v: %v\n", calendarID, state)
+	if state == "sync" {
+		// Sent once when a channel is created; nothing has changed.
+		return
+	}
+	watcher.markChanged(calendarID)
+	select {
+	case watcher.Notifications <- struct{}{}:
+	default:
+		// A fetch is already pending, which will pick this change up too.
+	}
+}
+
+// How far past the end of the requested window watchedLister fetches, so that cached results
+// still cover the window as it moves forward until the next fallback fetch.
+const watchedListWindow = 12 * time.Hour
+
+// cachedList is the result of the last fetch of one calendar.
+type cachedList struct {
+	events  []*calendar.Event
+	end     time.Time
+	fetched time.Time
+}
+
+// watchedLister wraps another lister, and while push notifications are active only fetches a
+// calendar again when it has changed or the fallback interval has passed.
+type watchedLister struct {
+	inner    CalendarLister
+	watcher  *CalendarWatcher
+	fallback time.Duration
+	cache    map[string]*cachedList
+}
+
+func NewWatchedLister(inner CalendarLister, watcher *CalendarWatcher, fallback time.Duration) CalendarLister {
+	return &watchedLister{
+		inner:    inner,
+		watcher:  watcher,
+		fallback: fallback,
+		cache:    make(map[string]*cachedList),
+	}
+}
+
+func (lister *watchedLister) List(calendarID string, start time.Time, end time.Time) ([]*calendar.Event, error) {
+	// Always take the change flag, so a change notified while inactive isn't applied twice.
+	changed := lister.watcher.TakeChanged(calendarID)
+	cached := lister.cache[calendarID]
+	if cached == nil || changed || !lister.watcher.Active() ||
+		time.Since(cached.fetched) > lister.fallback || end.After(cached.end) {
+		fetchEnd := end.Add(watchedListWindow)
+		events, err := lister.inner.List(calendarID, start, fetchEnd)
+		if err != nil {
+			if changed {
+				// Try again on the next poll.
+				lister.watcher.markChanged(calendarID)
+			}
+			return nil, err
+		}
+		cached = &cachedList{events: events, end: fetchEnd, fetched: time.Now()}
+		lister.cache[calendarID] = cached
+	} else {
+		verboseLog("Using cached events for calendar %v\n", calendarID)
+	}
+	var events []*calendar.Event
+	for _, event := range cached.events {
+		if eventOverlaps(eventStartTime(event), eventEndTime(event), start, end) {
+			events = append(events, event)
+		}
+	}
+	return events, nil
+}
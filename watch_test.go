@@ -0,0 +1,150 @@
+// Copyright 2024 Google Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// This file tests push notifications of calendar changes.
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"google.golang.org/api/calendar/v3"
+)
+
+// newTestWatcher returns a watcher for the primary calendar with a live channel, as if it had
+// been registered.
+func newTestWatcher() *CalendarWatcher {
+	watcher := NewCalendarWatcher(nil, []string{"primary"}, "https://calblink.example.com/notify")
+	watcher.channels["primary"] = &watchChannel{id: "channel-1", expiration: time.Now().Add(24 * time.Hour)}
+	watcher.channelCalendars["channel-1"] = "primary"
+	// A channel that has been replaced, which may still send a last notification.
+	watcher.channelCalendars["channel-0"] = "primary"
+	return watcher
+}
+
+func TestCalendarWatcherServeHTTP(t *testing.T) {
+	tests := []struct {
+		name        string
+		method      string
+		token       string
+		channel     string
+		state       string
+		wantStatus  int
+		wantChanged bool
+	}{
+		{"wrong method", http.MethodGet, "", "channel-1", "exists", http.StatusMethodNotAllowed, false},
+		{"bad token", http.MethodPost, "not-the-token", "channel-1", "exists", http.StatusForbidden, false},
+		{"missing token", http.MethodPost, "-", "channel-1", "exists", http.StatusForbidden, false},
+		{"unknown channel", http.MethodPost, "", "channel-9", "exists", http.StatusOK, false},
+		{"sync handshake", http.MethodPost, "", "channel-1", "sync", http.StatusOK, false},
+		{"change", http.MethodPost, "", "channel-1", "exists", http.StatusOK, true},
+		{"change on a replaced channel", http.MethodPost, "", "channel-0", "exists", http.StatusOK, true},
+	}
+	for _, test := range tests {
+		watcher := newTestWatcher()
+		server := httptest.NewServer(watcher)
+		req, err := http.NewRequest(test.method, server.URL, nil)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		switch test.token {
+		case "":
+			req.Header.Set("X-Goog-Channel-Token", watcher.token)
+		case "-":
+		default:
+			req.Header.Set("X-Goog-Channel-Token", test.token)
+		}
+		req.Header.Set("X-Goog-Channel-ID", test.channel)
+		req.Header.Set("X-Goog-Resource-State", test.state)
+		resp, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+		resp.Body.Close()
+		server.Close()
+
+		if resp.StatusCode != test.wantStatus {
+			t.Errorf("%v: status %v, want %v", test.name, resp.StatusCode, test.wantStatus)
+		}
+		notified := false
+		select {
+		case <-watcher.Notifications:
+			notified = true
+		default:
+		}
+		if notified != test.wantChanged {
+			t.Errorf("%v: notified = %v, want %v", test.name, notified, test.wantChanged)
+		}
+		if changed := watcher.TakeChanged("primary"); changed != test.wantChanged {
+			t.Errorf("%v: changed = %v, want %v", test.name, changed, test.wantChanged)
+		}
+		if watcher.TakeChanged("primary") {
+			t.Errorf("%v: TakeChanged didn't clear the change", test.name)
+		}
+	}
+}
+
+func TestCalendarWatcherActive(t *testing.T) {
+	watcher := newTestWatcher()
+	if !watcher.Active() {
+		t.Errorf("Active() = false with a live channel")
+	}
+	watcher.channels["primary"].expiration = time.Now().Add(-time.Minute)
+	if watcher.Active() {
+		t.Errorf("Active() = true with an expired channel")
+	}
+	watcher = newTestWatcher()
+	watcher.serverFailed = true
+	if watcher.Active() {
+		t.Errorf("Active() = true after the listener failed")
+	}
+}
+
+// countingLister records the windows it was asked for.
+type countingLister struct {
+	ends []time.Time
+}
+
+func (lister *countingLister) List(calendarID string, start time.Time, end time.Time) ([]*calendar.Event, error) {
+	lister.ends = append(lister.ends, end)
+	return nil, nil
+}
+
+func TestWatchedListerReusesCache(t *testing.T) {
+	watcher := newTestWatcher()
+	inner := &countingLister{}
+	lister := NewWatchedLister(inner, watcher, time.Hour)
+	now := time.Now()
+	// A day ahead, longer than watchedListWindow, as with useReminders.
+	window := 24 * time.Hour
+	for i := 0; i < 5; i++ {
+		start := now.Add(time.Duration(i) * time.Minute)
+		if _, err := lister.List("primary", start, start.Add(window)); err != nil {
+			t.Fatalf("List: %v", err)
+		}
+	}
+	if len(inner.ends) != 1 {
+		t.Errorf("fetched %d times while the window moved forward, want once", len(inner.ends))
+	}
+	watcher.markChanged("primary")
+	if _, err := lister.List("primary", now, now.Add(window)); err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(inner.ends) != 2 {
+		t.Errorf("fetched %d times after a change notification, want twice", len(inner.ends))
+	}
+}